@@ -0,0 +1,232 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	// volumeSnapshotAnnotation is set on the backed-up copy of a
+	// PersistentVolumeClaim and holds the name of the VolumeSnapshot taken
+	// for it, so the restore side can provision the new volume from the
+	// snapshot.
+	volumeSnapshotAnnotation = "scale-deployment.ark.heptio.com/csi-volume-snapshot"
+
+	// snapshotClassAnnotation can be set on a PersistentVolumeClaim to pick
+	// the VolumeSnapshotClass used for its snapshots; if it's absent, the
+	// cluster's default class is used.
+	snapshotClassAnnotation = "scale-deployment.ark.heptio.com/csi-snapshot-class"
+
+	snapshotGroupVersion = "snapshot.storage.k8s.io/v1alpha1"
+
+	snapshotPollInterval = 5 * time.Second
+	snapshotPollTimeout  = 10 * time.Minute
+)
+
+func main() {
+	impl := &CSIVolumeSnapshots{
+		log: plugin.NewLogger(),
+	}
+
+	if clientConfig, err := rest.InClusterConfig(); err != nil {
+		impl.log.WithError(err).Info("No in-cluster client config, CSI volume snapshots will not be taken")
+	} else {
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.clientPool = dynamic.NewDynamicClientPool(clientConfig)
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
+}
+
+// CSIVolumeSnapshots is a backup item action plugin for Heptio Ark that
+// snapshots PersistentVolumeClaims through the CSI external-snapshotter, by
+// creating a VolumeSnapshot object alongside each claim and waiting for it
+// to become ready. It gives clusters on CSI-only storage a snapshot path
+// that doesn't depend on the cloudprovider BlockStore API; the two can also
+// coexist, since this action only looks at PersistentVolumeClaims.
+type CSIVolumeSnapshots struct {
+	log        logrus.FieldLogger
+	clientPool dynamic.ClientPool
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to
+// PersistentVolumeClaims by default; the environment can override the
+// resources, namespaces, and label selector, e.g. to scope snapshots to
+// claims from a particular CSI driver's storage classes.
+func (p *CSIVolumeSnapshots) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("persistentvolumeclaims")
+}
+
+// Execute creates a VolumeSnapshot for the claim, waits for the snapshot to
+// be ready to use, and records its name in an annotation on the backed-up
+// copy so the restore side can provision the replacement volume from it.
+func (p *CSIVolumeSnapshots) Execute(item runtime.Unstructured, arkBackup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running CSIVolumeSnapshots backup item action")
+	defer p.log.Info("Done running CSIVolumeSnapshots backup item action")
+
+	if p.clientPool == nil {
+		return item, nil, nil
+	}
+
+	if arkBackup.Spec.SnapshotVolumes != nil && !*arkBackup.Spec.SnapshotVolumes {
+		p.log.Info("Backup has volume snapshots disabled, not snapshotting claim")
+		return item, nil, nil
+	}
+
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access persistentvolumeclaim metadata")
+	}
+	namespace := metadata.GetNamespace()
+	name := metadata.GetName()
+
+	volumeName, _, err := unstructured.NestedString(item.UnstructuredContent(), "spec", "volumeName")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.volumeName")
+	}
+	if volumeName == "" {
+		p.log.Infof("Claim %s/%s is not bound to a volume, not snapshotting it", namespace, name)
+		return item, nil, nil
+	}
+
+	client, err := p.snapshotClient(namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	snapshotName := arkBackup.Name + "-" + name
+
+	spec := map[string]interface{}{
+		"source": map[string]interface{}{
+			"kind": "PersistentVolumeClaim",
+			"name": name,
+		},
+	}
+	if class := metadata.GetAnnotations()[snapshotClassAnnotation]; class != "" {
+		spec["snapshotClassName"] = class
+	}
+
+	snapshot := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": snapshotGroupVersion,
+			"kind":       "VolumeSnapshot",
+			"metadata": map[string]interface{}{
+				"name":      snapshotName,
+				"namespace": namespace,
+				"labels": map[string]interface{}{
+					v1.BackupNameLabel: arkBackup.Name,
+					v1.BackupUIDLabel:  string(arkBackup.UID),
+				},
+			},
+			"spec": spec,
+		},
+	}
+
+	if _, err := client.Create(snapshot); err != nil {
+		return nil, nil, errors.Wrapf(err, "error creating volumesnapshot %s/%s", namespace, snapshotName)
+	}
+
+	p.log.Infof("Created volumesnapshot %s/%s for claim %s/%s, waiting for it to be ready", namespace, snapshotName, namespace, name)
+
+	if err := p.waitForSnapshot(client, namespace, snapshotName); err != nil {
+		return nil, nil, err
+	}
+
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[volumeSnapshotAnnotation] = snapshotName
+	metadata.SetAnnotations(annotations)
+
+	p.log.Infof("Volumesnapshot %s/%s is ready to use", namespace, snapshotName)
+
+	return item, nil, nil
+}
+
+// waitForSnapshot polls the VolumeSnapshot until the external-snapshotter
+// reports it ready to use, or fails the backup of the claim if the snapshot
+// errors or doesn't become ready in time.
+func (p *CSIVolumeSnapshots) waitForSnapshot(client dynamic.ResourceInterface, namespace, snapshotName string) error {
+	err := wait.PollImmediate(snapshotPollInterval, snapshotPollTimeout, func() (bool, error) {
+		snapshot, err := client.Get(snapshotName, metav1.GetOptions{})
+		if err != nil {
+			p.log.WithError(err).Warnf("Error getting volumesnapshot %s/%s, will retry", namespace, snapshotName)
+			return false, nil
+		}
+
+		if snapshotErr, found, err := unstructured.NestedMap(snapshot.UnstructuredContent(), "status", "error"); err == nil && found {
+			message, _, _ := unstructured.NestedString(snapshotErr, "message")
+			return false, errors.Errorf("volumesnapshot %s/%s failed: %s", namespace, snapshotName, message)
+		}
+
+		ready, _, err := unstructured.NestedBool(snapshot.UnstructuredContent(), "status", "readyToUse")
+		if err != nil {
+			return false, errors.Wrap(err, "error getting .status.readyToUse")
+		}
+
+		return ready, nil
+	})
+	if err == wait.ErrWaitTimeout {
+		return errors.Errorf("timed out waiting for volumesnapshot %s/%s to be ready", namespace, snapshotName)
+	}
+
+	return err
+}
+
+// snapshotClient returns a dynamic client for VolumeSnapshots in the given
+// namespace.
+func (p *CSIVolumeSnapshots) snapshotClient(namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(snapshotGroupVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing groupVersion %q", snapshotGroupVersion)
+	}
+
+	client, err := p.clientPool.ClientForGroupVersionKind(gv.WithKind(""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting dynamic client for %s", gv)
+	}
+
+	apiResource := &metav1.APIResource{
+		Name:       "volumesnapshots",
+		Namespaced: true,
+	}
+
+	return client.Resource(apiResource, namespace), nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/mutation"
+)
+
+func main() {
+	log := plugin.NewLogger()
+
+	engine, err := mutation.NewEngineFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid mutation rules")
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(&MutateItems{
+		log:    log,
+		engine: engine,
+	}))
+}
+
+// MutateItems is a backup item action plugin for Heptio Ark that applies
+// config-driven patch rules to backed-up items, so field tweaks can be
+// configured per GroupKind instead of each needing a new plugin.
+type MutateItems struct {
+	log    logrus.FieldLogger
+	engine *mutation.Engine
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to deployments
+// by default, matching the default rule set; the environment can override
+// the resources, namespaces, and label selector.
+func (p *MutateItems) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("deployments.apps")
+}
+
+// Execute applies the backup patches of all rules matching the item's
+// GroupKind.
+func (p *MutateItems) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running MutateItems backup item action")
+	defer p.log.Info("Done running MutateItems backup item action")
+
+	if err := p.engine.ApplyBackup(item); err != nil {
+		return nil, nil, err
+	}
+
+	return item, nil, nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// originalHPASpecAnnotation records the original minReplicas, maxReplicas,
+// and metrics of a HorizontalPodAutoscaler as JSON, so the restore side can
+// put them back exactly once the target workload is available.
+const originalHPASpecAnnotation = "scale-deployment.ark.heptio.com/original-hpa-spec"
+
+func main() {
+	impl := &NeutralizeHorizontalPodAutoscalers{
+		log: plugin.NewLogger(),
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
+}
+
+// NeutralizeHorizontalPodAutoscalers is a backup item action plugin for
+// Heptio Ark that makes HorizontalPodAutoscalers inert in the backup, so a
+// restored HPA doesn't scale a workload back up while its data is still
+// being restored. The original settings are preserved in an annotation for
+// the restore side.
+type NeutralizeHorizontalPodAutoscalers struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to
+// HorizontalPodAutoscalers by default; the environment can override the
+// resources, namespaces, and label selector.
+func (p *NeutralizeHorizontalPodAutoscalers) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("horizontalpodautoscalers.autoscaling")
+}
+
+// Execute records the HPA's original minReplicas, maxReplicas, and metrics
+// in an annotation, then pins the HPA to a single replica with no metrics.
+func (p *NeutralizeHorizontalPodAutoscalers) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running NeutralizeHorizontalPodAutoscalers backup item action")
+	defer p.log.Info("Done running NeutralizeHorizontalPodAutoscalers backup item action")
+
+	profile, err := config.ProfileForBackup(backup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !profile.HandlesKind("horizontalpodautoscalers.autoscaling") {
+		p.log.Info("Backup's quiesce profile doesn't cover horizontalpodautoscalers, not modifying item")
+		return item, nil, nil
+	}
+
+	spec, found, err := unstructured.NestedMap(item.UnstructuredContent(), "spec")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec")
+	}
+	if !found {
+		return item, nil, nil
+	}
+
+	original := make(map[string]interface{})
+	for _, field := range []string{"minReplicas", "maxReplicas", "metrics", "targetCPUUtilizationPercentage"} {
+		if val, ok := spec[field]; ok {
+			original[field] = val
+		}
+	}
+
+	originalJSON, err := json.Marshal(original)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error encoding original HPA settings")
+	}
+
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access horizontalpodautoscaler metadata")
+	}
+
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[originalHPASpecAnnotation] = string(originalJSON)
+	metadata.SetAnnotations(annotations)
+
+	if err := unstructured.SetNestedField(item.UnstructuredContent(), int64(1), "spec", "minReplicas"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.minReplicas")
+	}
+	if err := unstructured.SetNestedField(item.UnstructuredContent(), int64(1), "spec", "maxReplicas"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.maxReplicas")
+	}
+	unstructured.RemoveNestedField(item.UnstructuredContent(), "spec", "metrics")
+	unstructured.RemoveNestedField(item.UnstructuredContent(), "spec", "targetCPUUtilizationPercentage")
+
+	return item, nil, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// originalUpdateModeAnnotation records the .spec.updatePolicy.updateMode a
+// VerticalPodAutoscaler had when it was backed up, so it can be put back
+// once restored pods are healthy.
+const originalUpdateModeAnnotation = "scale-deployment.ark.heptio.com/original-update-mode"
+
+func main() {
+	impl := &NeutralizeVerticalPodAutoscalers{
+		log: plugin.NewLogger(),
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
+}
+
+// NeutralizeVerticalPodAutoscalers is a backup item action plugin for Heptio
+// Ark that sets VerticalPodAutoscalers' updateMode to "Off" in the backup,
+// so restored pods aren't immediately evicted by VPA while data is still
+// being restored.
+type NeutralizeVerticalPodAutoscalers struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to
+// VerticalPodAutoscalers by default; the environment can override the
+// resources, namespaces, and label selector.
+func (p *NeutralizeVerticalPodAutoscalers) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("verticalpodautoscalers.autoscaling.k8s.io")
+}
+
+// Execute records the original .spec.updatePolicy.updateMode in an
+// annotation and sets the field to "Off".
+func (p *NeutralizeVerticalPodAutoscalers) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running NeutralizeVerticalPodAutoscalers backup item action")
+	defer p.log.Info("Done running NeutralizeVerticalPodAutoscalers backup item action")
+
+	profile, err := config.ProfileForBackup(backup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !profile.HandlesKind("verticalpodautoscalers.autoscaling.k8s.io") {
+		p.log.Info("Backup's quiesce profile doesn't cover verticalpodautoscalers, not modifying item")
+		return item, nil, nil
+	}
+
+	updateMode, found, err := unstructured.NestedString(item.UnstructuredContent(), "spec", "updatePolicy", "updateMode")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.updatePolicy.updateMode")
+	}
+
+	if found {
+		metadata, err := meta.Accessor(item)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to access verticalpodautoscaler metadata")
+		}
+
+		annotations := metadata.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[originalUpdateModeAnnotation] = updateMode
+		metadata.SetAnnotations(annotations)
+	}
+
+	if err := unstructured.SetNestedField(item.UnstructuredContent(), "Off", "spec", "updatePolicy", "updateMode"); err != nil {
+		p.log.WithError(err).Error("Error setting .spec.updatePolicy.updateMode")
+		return nil, nil, err
+	}
+
+	return item, nil, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// originalRunningAnnotation records the value of .spec.running that a
+// VirtualMachine had when it was backed up, so the restore side can put
+// it back.
+const originalRunningAnnotation = "scale-deployment.ark.heptio.com/original-running"
+
+func main() {
+	impl := &PauseVirtualMachines{
+		log: plugin.NewLogger(),
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
+}
+
+// PauseVirtualMachines is a backup item action plugin for Heptio Ark that
+// stops KubeVirt VirtualMachines in the backup, giving VM workloads the
+// same quiesce semantics that Deployments get from the scale-to-zero
+// action.
+type PauseVirtualMachines struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to KubeVirt
+// VirtualMachines by default; the environment can override the resources,
+// namespaces, and label selector.
+func (p *PauseVirtualMachines) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("virtualmachines.kubevirt.io")
+}
+
+// Execute records the original value of .spec.running in an annotation and
+// sets the field to false.
+func (p *PauseVirtualMachines) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running PauseVirtualMachines backup item action")
+	defer p.log.Info("Done running PauseVirtualMachines backup item action")
+
+	profile, err := config.ProfileForBackup(backup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !profile.HandlesKind("virtualmachines.kubevirt.io") {
+		p.log.Info("Backup's quiesce profile doesn't cover virtualmachines, not modifying item")
+		return item, nil, nil
+	}
+
+	running, found, err := unstructured.NestedBool(item.UnstructuredContent(), "spec", "running")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.running")
+	}
+
+	if found {
+		metadata, err := meta.Accessor(item)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to access virtualmachine metadata")
+		}
+
+		annotations := metadata.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[originalRunningAnnotation] = strconv.FormatBool(running)
+		metadata.SetAnnotations(annotations)
+	}
+
+	if err := unstructured.SetNestedField(item.UnstructuredContent(), false, "spec", "running"); err != nil {
+		p.log.WithError(err).Error("Error setting .spec.running")
+		return nil, nil, err
+	}
+
+	return item, nil, nil
+}
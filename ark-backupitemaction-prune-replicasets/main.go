@@ -0,0 +1,146 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	// prunedAnnotation marks a ReplicaSet whose pod template was pruned
+	// from the backup.
+	prunedAnnotation = "scale-deployment.ark.heptio.com/pruned"
+
+	// prunedImage is a placeholder for the pruned pod template's
+	// container, keeping the stub valid for restore.
+	prunedImage = "gcr.io/google-containers/pause:3.0"
+)
+
+func main() {
+	plugin.Serve(plugin.NewBackupItemActionPlugin(&PruneReplicaSets{
+		log: plugin.NewLogger(),
+	}))
+}
+
+// PruneReplicaSets is a backup item action plugin for Heptio Ark that
+// shrinks old revision-history ReplicaSets in the backup. Ark's plugin API
+// can't drop an item from a backup entirely, so zero-replica ReplicaSets
+// owned by a Deployment are instead reduced to a minimal stub: the pod
+// template — the bulk of a ReplicaSet — is replaced with a placeholder
+// that still satisfies the selector. Restored stubs sit at zero replicas
+// until the Deployment controller's revision-history cleanup removes them.
+type PruneReplicaSets struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to ReplicaSets
+// by default; the environment can override the resources, namespaces, and
+// label selector.
+func (p *PruneReplicaSets) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("replicasets.apps")
+}
+
+// Execute prunes the pod template of a zero-replica ReplicaSet owned by a
+// Deployment; live ReplicaSets of the current revision have nonzero
+// replicas and are backed up untouched.
+func (p *PruneReplicaSets) Execute(item runtime.Unstructured, arkBackup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running PruneReplicaSets backup item action")
+	defer p.log.Info("Done running PruneReplicaSets backup item action")
+
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access replicaset metadata")
+	}
+
+	ownedByDeployment := false
+	for _, ref := range metadata.GetOwnerReferences() {
+		if ref.Kind == "Deployment" {
+			ownedByDeployment = true
+			break
+		}
+	}
+	if !ownedByDeployment {
+		return item, nil, nil
+	}
+
+	replicas, found, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.replicas")
+	}
+	if !found || replicas != 0 {
+		return item, nil, nil
+	}
+
+	templateLabels, _, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "template", "metadata", "labels")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.template.metadata.labels")
+	}
+
+	// The placeholder template keeps the stub valid: it carries the
+	// original labels so the selector still matches, with a single pause
+	// container in place of the original pod spec.
+	template := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": stringMapToInterface(templateLabels),
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":  "pruned",
+					"image": prunedImage,
+				},
+			},
+		},
+	}
+	if err := unstructured.SetNestedMap(item.UnstructuredContent(), template, "spec", "template"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.template")
+	}
+
+	unstructured.RemoveNestedField(item.UnstructuredContent(), "status")
+
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[prunedAnnotation] = "true"
+	metadata.SetAnnotations(annotations)
+
+	p.log.Infof("Pruned revision-history replicaset %s/%s", metadata.GetNamespace(), metadata.GetName())
+
+	return item, nil, nil
+}
+
+// stringMapToInterface converts a map[string]string to the form
+// SetNestedMap expects.
+func stringMapToInterface(in map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
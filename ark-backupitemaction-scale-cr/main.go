@@ -0,0 +1,217 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// originalInstancesAnnotation records the instance counts an operator CR
+// had when it was backed up — a plain number for a single-field handler,
+// or comma-separated "field.path=value" pairs for handlers covering
+// several fields — so the operator-managed database can be scaled back up
+// after a restore.
+const originalInstancesAnnotation = "scale-deployment.ark.heptio.com/original-instances"
+
+// operatorHandler describes how to quiesce one operator's CR: annotations
+// to set so the operator stops reconciling, the instance-count fields to
+// scale, the value to scale them to, and whether the original values are
+// recorded for the restore side.
+type operatorHandler struct {
+	pauseAnnotations map[string]string
+	fieldPaths       [][]string
+	zeroValue        int64
+	restoreStrategy  string
+}
+
+// builtinHandlers covers operators known to actively fight raw
+// StatefulSet scaling during a backup, so they work without any
+// configuration. A configured rule for the same GroupKind replaces the
+// built-in.
+var builtinHandlers = map[string]operatorHandler{
+	// Zalando postgres-operator.
+	"postgresql.acid.zalan.do": {
+		fieldPaths: [][]string{{"spec", "numberOfInstances"}},
+	},
+	// Strimzi re-creates pods the moment a Kafka StatefulSet shrinks;
+	// pausing reconciliation keeps it out of the way until the restored
+	// CR is unpaused.
+	"Kafka.kafka.strimzi.io": {
+		pauseAnnotations: map[string]string{"strimzi.io/pause-reconciliation": "true"},
+		fieldPaths: [][]string{
+			{"spec", "kafka", "replicas"},
+			{"spec", "zookeeper", "replicas"},
+		},
+	},
+	// The MongoDB Community operator reconciles the replica set to
+	// .spec.members, so the member count is zeroed through the CR.
+	"MongoDBCommunity.mongodbcommunity.mongodb.com": {
+		fieldPaths: [][]string{{"spec", "members"}},
+	},
+}
+
+func main() {
+	log := plugin.NewLogger()
+
+	rules, err := config.LoadCRScaleRules()
+	if err != nil {
+		log.WithError(err).Fatal("Invalid CR scale rules")
+	}
+
+	handlers := make(map[string]operatorHandler, len(builtinHandlers)+len(rules))
+	for groupKind, handler := range builtinHandlers {
+		handlers[groupKind] = handler
+	}
+	for groupKind, rule := range rules {
+		handlers[groupKind] = operatorHandler{
+			fieldPaths:      [][]string{rule.FieldPath},
+			zeroValue:       rule.ZeroValue,
+			restoreStrategy: rule.RestoreStrategy,
+		}
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(&ScaleCRs{
+		log:      log,
+		handlers: handlers,
+	}))
+}
+
+// ScaleCRs is a backup item action plugin for Heptio Ark that quiesces
+// database operator CRs — zeroing their instance-count fields and, where
+// the operator needs it, pausing its reconciliation — so operator-managed
+// databases are quiesced through their operator rather than by scaling
+// the StatefulSets it owns behind its back. Zalando postgres-operator,
+// Strimzi Kafka, and the MongoDB Community operator are handled out of
+// the box; other CRs via configured field paths.
+type ScaleCRs struct {
+	log      logrus.FieldLogger
+	handlers map[string]operatorHandler
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to the CRs of
+// the built-in handlers by default; the environment must widen the
+// resources when rules for other operators are configured.
+func (p *ScaleCRs) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv(
+		"postgresqls.acid.zalan.do",
+		"kafkas.kafka.strimzi.io",
+		"mongodbcommunity.mongodbcommunity.mongodb.com",
+	)
+}
+
+// Execute applies the handler for the CR's GroupKind: pause annotations
+// are set, and each instance-count field present is recorded in an
+// annotation and zeroed. CRs without a matching handler pass through
+// unmodified.
+func (p *ScaleCRs) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running ScaleCRs backup item action")
+	defer p.log.Info("Done running ScaleCRs backup item action")
+
+	profile, err := config.ProfileForBackup(backup)
+	if err != nil {
+		return nil, nil, err
+	}
+	if profile.Skip {
+		p.log.Info("Backup's quiesce profile is skip, not modifying item")
+		return item, nil, nil
+	}
+
+	content := item.UnstructuredContent()
+	apiVersion, _ := content["apiVersion"].(string)
+	kind, _ := content["kind"].(string)
+	gk := schema.FromAPIVersionAndKind(apiVersion, kind).GroupKind()
+	groupKind := gk.String()
+
+	handler, ok := p.handlers[groupKind]
+	if !ok {
+		p.log.Infof("No CR scale handler for %s, not modifying item", groupKind)
+		return item, nil, nil
+	}
+
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access CR metadata")
+	}
+
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+
+	var (
+		recorded []string
+		patched  int
+	)
+	for _, fieldPath := range handler.fieldPaths {
+		instances, found, err := unstructured.NestedInt64(content, fieldPath...)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error getting .%s", strings.Join(fieldPath, "."))
+		}
+		if !found {
+			continue
+		}
+
+		if err := unstructured.SetNestedField(content, handler.zeroValue, fieldPath...); err != nil {
+			p.log.WithError(err).Errorf("Error setting .%s", strings.Join(fieldPath, "."))
+			return nil, nil, err
+		}
+		patched++
+
+		if handler.restoreStrategy != config.CRRestoreStrategyNone {
+			if len(handler.fieldPaths) == 1 {
+				recorded = append(recorded, strconv.FormatInt(instances, 10))
+			} else {
+				recorded = append(recorded, fmt.Sprintf("%s=%d", strings.Join(fieldPath, "."), instances))
+			}
+		}
+
+		p.log.Infof("Scaled .%s of %s %s/%s to %d (was %d)",
+			strings.Join(fieldPath, "."), groupKind, metadata.GetNamespace(), metadata.GetName(), handler.zeroValue, instances)
+	}
+
+	if patched == 0 && len(handler.pauseAnnotations) == 0 {
+		p.log.Infof("%s has none of the handled fields, not modifying item", groupKind)
+		return item, nil, nil
+	}
+
+	if len(recorded) > 0 {
+		annotations[originalInstancesAnnotation] = strings.Join(recorded, ",")
+	}
+	for key, val := range handler.pauseAnnotations {
+		annotations[key] = val
+		p.log.Infof("Set %s=%s on %s %s/%s", key, val, groupKind, metadata.GetNamespace(), metadata.GetName())
+	}
+	metadata.SetAnnotations(annotations)
+
+	return item, nil, nil
+}
@@ -17,45 +17,1346 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
 
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 
 	"github.com/heptio/ark/pkg/apis/ark/v1"
 	"github.com/heptio/ark/pkg/backup"
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
 	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/archive"
+	"github.com/skriss/ark-scale-deployment/pkg/buildinfo"
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/controller"
+	"github.com/skriss/ark-scale-deployment/pkg/manifest"
+	"github.com/skriss/ark-scale-deployment/pkg/metrics"
+	"github.com/skriss/ark-scale-deployment/pkg/notify"
+	"github.com/skriss/ark-scale-deployment/pkg/quiesce"
+	"github.com/skriss/ark-scale-deployment/pkg/report"
+	"github.com/skriss/ark-scale-deployment/pkg/trace"
+	"github.com/skriss/ark-scale-deployment/pkg/validation"
+)
+
+const (
+	// liveQuiesceEnvVar enables live quiesce mode: in addition to zeroing
+	// .spec.replicas in the backed-up copy, the action scales the live
+	// Deployment down so PV snapshots capture a quiesced state.
+	liveQuiesceEnvVar = "ARK_SCALE_LIVE_QUIESCE"
+
+	// workersEnvVar bounds the number of concurrent API writes in live
+	// quiesce mode.
+	workersEnvVar  = "ARK_SCALE_WORKERS"
+	defaultWorkers = 10
+
+	// haCriticalLabel marks a deployment that must not be fully scaled
+	// down: it's scaled to max(1, replicas - maxUnavailable) instead, so
+	// the backup captures a mostly quiesced state without a full outage.
+	haCriticalLabel = "scale-deployment.ark.heptio.com/ha-critical"
+
+	// skippedDueToErrorAnnotation is set on the backed-up copy of an item
+	// that failed to process under the skip error policy, and records why.
+	skippedDueToErrorAnnotation = "scale-deployment.ark.heptio.com/skipped-due-to-error"
+
+	// includeRBACEnvVar makes the action return the deployment's
+	// ServiceAccount and the Role/RoleBindings binding to it as additional
+	// items, so a workload-focused backup is restorable without manual
+	// RBAC recreation.
+	includeRBACEnvVar = "ARK_SCALE_INCLUDE_RBAC"
+
+	// includeServicesEnvVar makes the action return Services whose
+	// selectors match the deployment's pod labels as additional items, so
+	// label-selector-scoped backups capture the full serving path.
+	includeServicesEnvVar = "ARK_SCALE_INCLUDE_SERVICES"
+
+	// gitopsModeEnvVar removes .spec.replicas from the backed-up copy
+	// entirely instead of setting it to the target, so after restore the
+	// field is unmanaged and GitOps/HPA controllers set the authoritative
+	// count without a fight. The original value is still recorded via the
+	// configured persistence mode.
+	gitopsModeEnvVar = "ARK_SCALE_GITOPS_MODE"
 )
 
 func main() {
+	// The legacy --validate spelling predates the CLI; keep it working for
+	// existing deployment manifests.
+	if len(os.Args) > 1 && os.Args[1] == "--validate" {
+		os.Args[1] = "validate-config"
+	}
+
+	if err := newCommand().Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+// newCommand builds the binary's CLI. Running with no arguments serves the
+// plugin, which is how Ark invokes it; the subcommands exist so operators
+// can introspect the plugin without reading source.
+func newCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "ark-backupitemaction-scale-deployment",
+		Short: "Ark backup item action that scales deployments down at backup time",
+		Run: func(cmd *cobra.Command, args []string) {
+			serve()
+		},
+	}
+
+	root.AddCommand(
+		&cobra.Command{
+			Use:   "serve",
+			Short: "Serve the backup item action plugin (the default)",
+			Run: func(cmd *cobra.Command, args []string) {
+				serve()
+			},
+		},
+		&cobra.Command{
+			Use:   "version",
+			Short: "Print version information",
+			Run: func(cmd *cobra.Command, args []string) {
+				fmt.Printf("Version: %s\n", buildinfo.Version)
+				fmt.Printf("Git SHA: %s\n", buildinfo.GitSHA)
+			},
+		},
+		&cobra.Command{
+			Use:   "validate-config",
+			Short: "Validate the plugin's environment configuration and, with live quiesce enabled, its RBAC permissions",
+			Run: func(cmd *cobra.Command, args []string) {
+				os.Exit(runValidate())
+			},
+		},
+		&cobra.Command{
+			Use:   "print-default-config",
+			Short: "Print the plugin's configuration environment variables and their defaults",
+			Run: func(cmd *cobra.Command, args []string) {
+				fmt.Print(defaultConfig)
+			},
+		},
+		newMutateCommand(),
+		newRewriteArchiveCommand(),
+		newInspectCommand(),
+		newSimulateRestoreCommand(),
+		&cobra.Command{
+			Use:   "rbac [namespace [name]]",
+			Short: "Print the ClusterRole and ClusterRoleBinding YAML the plugin needs",
+			Run: func(cmd *cobra.Command, args []string) {
+				os.Exit(runRBAC(args))
+			},
+		},
+	)
+
+	return root
+}
+
+// defaultConfig documents every configuration environment variable the
+// plugin reads, in a form that can be pasted into a deployment manifest.
+const defaultConfig = `# Configuration environment variables and their defaults.
+# Unset variables take the default shown; empty means the feature is off.
+
+# AppliesTo selector overrides (comma-separated lists).
+ARK_SCALE_RESOURCES=deployments.apps
+ARK_SCALE_NAMESPACES=
+ARK_SCALE_EXCLUDED_NAMESPACES=
+ARK_SCALE_LABEL_SELECTOR=
+
+# Live quiesce: scale live workloads down so snapshots are consistent.
+ARK_SCALE_LIVE_QUIESCE=false
+ARK_SCALE_WORKERS=10
+
+# Mark quiesced namespaces as under maintenance: configmap or
+# ingress-annotation.
+ARK_SCALE_MAINTENANCE_MODE=
+
+# Infer workload dependencies from the service graph when no depends-on
+# annotation is present.
+ARK_SCALE_INFER_DEPENDENCIES=false
+
+# Where the original replica count is recorded: annotation or configmap.
+ARK_SCALE_PERSISTENCE_MODE=annotation
+
+# Error handling per item: fail, warn, or skip; "warn,prod=fail" scopes
+# a policy to one namespace.
+ARK_SCALE_ERROR_POLICY=fail
+
+# Optional filter expressions over the item's content; see pkg/config
+# for the supported grammar.
+ARK_SCALE_FILTER_EXPRESSION=
+ARK_SCALE_TARGET_REPLICAS_EXPRESSION=
+
+# Patch rules applied to backed-up and restored items (JSON array).
+ARK_SCALE_MUTATION_RULES=
+ARK_SCALE_STRIP_SERVER_FIELDS=false
+
+# Additional related items to pull into the backup.
+ARK_SCALE_INCLUDE_RBAC=false
+ARK_SCALE_INCLUDE_SERVICES=false
+
+# GitOps mode: drop .spec.replicas from the backed-up copy entirely.
+ARK_SCALE_GITOPS_MODE=false
+
+# Source cluster identity stamped onto mutated items.
+ARK_SCALE_CLUSTER_NAME=
+
+# ConfigMap ("namespace/name") whose data overrides these variables; it's
+# watched, and changes apply without a restart.
+ARK_SCALE_POLICY_CONFIGMAP=
+
+# Maintain a ScaleActionReport CR per backup summarizing per-item decisions.
+ARK_SCALE_ACTION_REPORT=false
+
+# Webhook notified when live quiesce of a namespace starts and completes;
+# format is json or slack.
+ARK_SCALE_NOTIFY_WEBHOOK=
+ARK_SCALE_NOTIFY_FORMAT=json
+
+# Observability: Prometheus metrics listen address and trace collector URL.
+ARK_SCALE_METRICS_ADDR=
+ARK_SCALE_TRACE_COLLECTOR=
+`
+
+// newMutateCommand returns the mutate subcommand: it runs the action's
+// archive-side mutation logic against a local manifest and prints the
+// result, for debugging policy behavior and for use in pipelines. The same
+// environment variables apply; live quiesce and ConfigMap persistence are
+// off since there's no cluster.
+func newMutateCommand() *cobra.Command {
+	var (
+		filename string
+		target   int64
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mutate -f FILENAME [--target N]",
+		Short: "Apply the action's mutation logic to a local manifest and print the result",
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runMutate(filename, target, cmd.Flags().Changed("target")))
+		},
+	}
+
+	cmd.Flags().StringVarP(&filename, "filename", "f", "", "manifest to mutate, as JSON; - reads stdin")
+	cmd.MarkFlagRequired("filename")
+	cmd.Flags().Int64Var(&target, "target", 0, "replica count to scale to, overriding the configured profiles and expressions")
+
+	return cmd
+}
+
+func runMutate(filename string, target int64, targetSet bool) int {
+	log := logrus.New()
+	log.Out = os.Stderr
+
+	var (
+		data []byte
+		err  error
+	)
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		log.WithError(err).Error("Error reading manifest")
+		return 1
+	}
+
+	item := &unstructured.Unstructured{}
+	if err := json.Unmarshal(data, &item.Object); err != nil {
+		log.WithError(err).Error("Error parsing manifest")
+		return 1
+	}
+
+	policy, err := loadPolicyConfig()
+	if err != nil {
+		log.WithError(err).Error("Invalid configuration")
+		return 1
+	}
+	// There's no cluster to write a ConfigMap to.
+	policy.persistenceMode = config.PersistenceModeAnnotations
+	if targetSet {
+		if policy.targetReplicasExpression, err = config.CompileExpression(strconv.FormatInt(target, 10)); err != nil {
+			log.WithError(err).Error("Error compiling target replicas override")
+			return 1
+		}
+	}
+
+	impl := &ScaleDeploymentsToZeroReplicas{log: log}
+	impl.policy.Store(policy)
+
+	offlineBackup := &v1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: v1.DefaultNamespace,
+			Name:      "offline",
+		},
+	}
+
+	mutated, _, err := impl.execute(item, offlineBackup)
+	if err != nil {
+		log.WithError(err).Error("Error mutating manifest")
+		return 1
+	}
+
+	out, err := json.MarshalIndent(mutated.UnstructuredContent(), "", "  ")
+	if err != nil {
+		log.WithError(err).Error("Error encoding result")
+		return 1
+	}
+	fmt.Println(string(out))
+
+	return 0
+}
+
+// newRewriteArchiveCommand returns the rewrite-archive subcommand: it
+// applies the action's mutation logic to every matching item inside a
+// downloaded backup archive and repacks it, so backups taken before the
+// plugin was installed can still be made restore-safe.
+func newRewriteArchiveCommand() *cobra.Command {
+	var filename, output string
+
+	cmd := &cobra.Command{
+		Use:   "rewrite-archive -f BACKUP.tar.gz -o REWRITTEN.tar.gz",
+		Short: "Apply the action's mutation logic to the items in a backup archive",
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runRewriteArchive(filename, output))
+		},
+	}
+
+	cmd.Flags().StringVarP(&filename, "filename", "f", "", "backup archive to rewrite")
+	cmd.MarkFlagRequired("filename")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "where to write the rewritten archive")
+	cmd.MarkFlagRequired("output")
+
+	return cmd
+}
+
+func runRewriteArchive(filename, output string) int {
+	log := logrus.New()
+	log.Out = os.Stderr
+
+	policy, err := loadPolicyConfig()
+	if err != nil {
+		log.WithError(err).Error("Invalid configuration")
+		return 1
+	}
+	// There's no cluster to write a ConfigMap to.
+	policy.persistenceMode = config.PersistenceModeAnnotations
+
+	impl := &ScaleDeploymentsToZeroReplicas{log: log}
+	impl.policy.Store(policy)
+
+	offlineBackup := &v1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: v1.DefaultNamespace,
+			Name:      strings.TrimSuffix(filepath.Base(filename), ".tar.gz"),
+		},
+	}
+
+	in, err := os.Open(filename)
+	if err != nil {
+		log.WithError(err).Error("Error opening archive")
+		return 1
+	}
+	defer in.Close()
+
+	out, err := os.Create(output)
+	if err != nil {
+		log.WithError(err).Error("Error creating output archive")
+		return 1
+	}
+	defer out.Close()
+
+	rewritten := 0
+	err = archive.Rewrite(in, out, func(path archive.ItemPath, item *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		if path.GroupResource != "deployments.apps" {
+			return nil, nil
+		}
+
+		mutated, _, err := impl.execute(item, offlineBackup)
+		if err != nil {
+			return nil, err
+		}
+		rewritten++
+		return mutated.(*unstructured.Unstructured), nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Error rewriting archive")
+		os.Remove(output)
+		return 1
+	}
+
+	log.Infof("Rewrote %d items into %s", rewritten, output)
+	return 0
+}
+
+// newInspectCommand returns the inspect subcommand: it lists every
+// workload in a backup archive with its replica counts and whether the
+// plugin mutated it, for a quick pre-restore audit without a cluster.
+func newInspectCommand() *cobra.Command {
+	var filename string
+
+	cmd := &cobra.Command{
+		Use:   "inspect -f BACKUP.tar.gz",
+		Short: "List the workloads in a backup archive and their stored replica counts",
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runInspect(filename))
+		},
+	}
+
+	cmd.Flags().StringVarP(&filename, "filename", "f", "", "backup archive to inspect")
+	cmd.MarkFlagRequired("filename")
+
+	return cmd
+}
+
+// workloadGroupResources are the archive directories inspect and
+// simulate-restore treat as scalable workloads.
+var workloadGroupResources = map[string]bool{
+	"deployments.apps":  true,
+	"statefulsets.apps": true,
+}
+
+func runInspect(filename string) int {
+	in, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	defer in.Close()
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "NAMESPACE\tNAME\tKIND\tREPLICAS\tORIGINAL\tMUTATED")
+
+	err = archive.Walk(in, func(path archive.ItemPath, item *unstructured.Unstructured) error {
+		if !workloadGroupResources[path.GroupResource] {
+			return nil
+		}
+
+		replicas := "<none>"
+		if val, found, _ := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas"); found {
+			replicas = strconv.FormatInt(val, 10)
+		}
+
+		annotations, _, _ := unstructured.NestedStringMap(item.UnstructuredContent(), "metadata", "annotations")
+		original, mutated := annotations[controller.OriginalReplicasAnnotation]
+		if original == "" {
+			original = "<none>"
+		}
+		if _, skipped := annotations[skippedDueToErrorAnnotation]; skipped {
+			mutated = false
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%t\n",
+			path.Namespace, path.Name, item.GetKind(), replicas, original, mutated)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	writer.Flush()
+	return 0
+}
+
+// newSimulateRestoreCommand returns the simulate-restore subcommand: it
+// prints the replica counts and scale-up ordering the restore action and
+// scale-restore controller would produce for a backup archive, so DR plans
+// can be reviewed offline. The restore action's environment configuration
+// (namespace replica defaults, cluster name) applies; --set overrides
+// individual workloads.
+func newSimulateRestoreCommand() *cobra.Command {
+	var (
+		filename  string
+		overrides []string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "simulate-restore -f BACKUP.tar.gz [--set namespace/name=N]",
+		Short: "Print the replica counts and ordering a restore of the archive would produce",
+		Run: func(cmd *cobra.Command, args []string) {
+			os.Exit(runSimulateRestore(filename, overrides))
+		},
+	}
+
+	cmd.Flags().StringVarP(&filename, "filename", "f", "", "backup archive to simulate restoring")
+	cmd.MarkFlagRequired("filename")
+	cmd.Flags().StringArrayVar(&overrides, "set", nil, "override one workload's replica count, as namespace/name=N; repeatable")
+
+	return cmd
+}
+
+// simulatedWorkload is one row of the simulate-restore plan.
+type simulatedWorkload struct {
+	namespace string
+	name      string
+	kind      string
+	current   int64
+	intended  string
+	source    string
+	delay     string
+	ordinal   bool
+}
+
+func runSimulateRestore(filename string, overrides []string) int {
+	overrideReplicas := make(map[string]int64, len(overrides))
+	for _, override := range overrides {
+		parts := strings.SplitN(override, "=", 2)
+		if len(parts) != 2 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --set %q: expected namespace/name=N\n", override)
+			return 1
+		}
+		replicas, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --set %q: %v\n", override, err)
+			return 1
+		}
+		overrideReplicas[parts[0]] = replicas
+	}
+
+	defaults, err := config.LoadNamespaceReplicaDefaults()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	localCluster := os.Getenv(config.ClusterNameEnvVar)
+
+	in, err := os.Open(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	defer in.Close()
+
+	var workloads []simulatedWorkload
+	err = archive.Walk(in, func(path archive.ItemPath, item *unstructured.Unstructured) error {
+		if !workloadGroupResources[path.GroupResource] {
+			return nil
+		}
+
+		annotations := item.GetAnnotations()
+		workload := simulatedWorkload{
+			namespace: path.Namespace,
+			name:      path.Name,
+			kind:      item.GetKind(),
+			intended:  "as-is",
+			source:    "none recorded",
+			delay:     annotations[controller.RestoreDelayAnnotation],
+			ordinal:   annotations[controller.OrdinalScaleUpAnnotation] == "true",
+		}
+		workload.current, _, _ = unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas")
+
+		crossCluster := false
+		if sourceCluster, ok := annotations[config.SourceClusterNameAnnotation]; ok && localCluster != "" && sourceCluster != localCluster {
+			crossCluster = true
+		}
+
+		if original, ok := annotations[controller.OriginalReplicasAnnotation]; ok && !crossCluster {
+			workload.intended = original
+			workload.source = "original-replicas annotation"
+		} else if replicas, ok := defaults[path.Namespace]; ok {
+			workload.intended = strconv.FormatInt(replicas, 10)
+			workload.source = "namespace default"
+		}
+
+		if replicas, ok := overrideReplicas[path.Namespace+"/"+path.Name]; ok {
+			workload.intended = strconv.FormatInt(replicas, 10)
+			workload.source = "--set override"
+		}
+
+		workloads = append(workloads, workload)
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	// The scale-restore controller processes deployments before
+	// statefulsets; within each, order is by namespace then name.
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].kind != workloads[j].kind {
+			return workloads[i].kind == "Deployment"
+		}
+		if workloads[i].namespace != workloads[j].namespace {
+			return workloads[i].namespace < workloads[j].namespace
+		}
+		return workloads[i].name < workloads[j].name
+	})
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(writer, "ORDER\tNAMESPACE\tNAME\tKIND\tCURRENT\tINTENDED\tSOURCE\tDELAY\tORDINAL")
+	for i, workload := range workloads {
+		delay := workload.delay
+		if delay == "" {
+			delay = "-"
+		}
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%t\n",
+			i+1, workload.namespace, workload.name, workload.kind,
+			workload.current, workload.intended, workload.source, delay, workload.ordinal)
+	}
+	writer.Flush()
+
+	return 0
+}
+
+// serve configures the action from the environment and hands the process
+// over to the Ark plugin server.
+func serve() {
 	impl := &ScaleDeploymentsToZeroReplicas{
 		log: plugin.NewLogger(),
 	}
 
+	// Surface every configuration problem at once, before serving; bad
+	// config discovered midway through a backup is much harder to act on.
+	if errs := validation.CheckConfig(); len(errs) > 0 {
+		for _, err := range errs {
+			impl.log.WithError(err).Error("Invalid configuration")
+		}
+		impl.log.Fatal("Refusing to serve with invalid configuration; fix the errors above and restart")
+	}
+
+	impl.tracer = trace.NewTracerFromEnv(impl.log)
+
+	policy, err := loadPolicyConfig()
+	if err != nil {
+		impl.log.WithError(err).Fatal("Invalid configuration")
+	}
+	impl.policy.Store(policy)
+
+	liveQuiesce := os.Getenv(liveQuiesceEnvVar) == "true"
+	impl.clusterName = os.Getenv(config.ClusterNameEnvVar)
+
+	if liveQuiesce || policy.persistenceMode == config.PersistenceModeConfigMap ||
+		policy.includeRBAC || policy.includeServices || os.Getenv(config.PolicyConfigMapEnvVar) != "" {
+		clientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to get in-cluster client config")
+		}
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create kubernetes client")
+		}
+
+		if uid, err := config.ClusterUID(impl.kubeClient); err != nil {
+			impl.log.WithError(err).Warn("Unable to determine cluster UID, items won't be stamped with it")
+		} else {
+			impl.clusterUID = uid
+		}
+
+		if watcher, err := config.NewPolicyWatcherFromEnv(impl.log, impl.kubeClient); err != nil {
+			impl.log.WithError(err).Fatal("Invalid policy configmap reference")
+		} else if watcher != nil {
+			watcher.OnChange(func() {
+				if errs := validation.CheckConfig(); len(errs) > 0 {
+					for _, err := range errs {
+						impl.log.WithError(err).Error("Invalid policy configmap data")
+					}
+					impl.log.Error("Keeping the previous policy configuration")
+					return
+				}
+
+				updated, err := loadPolicyConfig()
+				if err != nil {
+					impl.log.WithError(err).Error("Error reloading configuration, keeping the previous one")
+					return
+				}
+				impl.policy.Store(updated)
+				impl.log.Info("Reloaded policy configuration")
+			})
+			go watcher.Run(make(chan struct{}))
+		}
+
+		if liveQuiesce {
+			if errs := validation.CheckNamespaces(impl.kubeClient); len(errs) > 0 {
+				for _, err := range errs {
+					impl.log.WithError(err).Error("Invalid configuration")
+				}
+				impl.log.Fatal("Refusing to serve: the AppliesTo selector names namespaces that don't exist")
+			}
+
+			workers := defaultWorkers
+			if val := os.Getenv(workersEnvVar); val != "" {
+				workers, err = strconv.Atoi(val)
+				if err != nil {
+					impl.log.WithError(err).Fatalf("Invalid value %q for %s", val, workersEnvVar)
+				}
+			}
+
+			impl.quiescer, err = quiesce.NewQuiescer(impl.log, impl.kubeClient, clientConfig, workers, make(chan struct{}))
+			if err != nil {
+				impl.log.WithError(err).Fatal("Unable to create quiescer")
+			}
+			impl.quiescer.SetTracer(impl.tracer)
+
+			impl.notifier, err = notify.NewNotifierFromEnv(impl.log)
+			if err != nil {
+				impl.log.WithError(err).Fatal("Invalid notification webhook configuration")
+			}
+			impl.quiescer.SetNotifier(impl.notifier)
+
+			if addr := os.Getenv(metrics.MetricsAddressEnvVar); addr != "" {
+				serverMetrics := metrics.NewServerMetrics()
+				serverMetrics.RegisterAllMetrics()
+				impl.quiescer.SetMetrics(serverMetrics)
+
+				go func() {
+					if err := metrics.Serve(addr); err != nil {
+						impl.log.WithError(err).Error("Error serving metrics")
+					}
+				}()
+			}
+		}
+	}
+
+	if os.Getenv(report.ReportEnvVar) == "true" {
+		clientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to get in-cluster client config")
+		}
+
+		impl.reporter = report.NewReporter(impl.log, clientConfig)
+	}
+
+	if os.Getenv(manifest.ManifestBucketEnvVar) != "" {
+		clientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to get in-cluster client config")
+		}
+
+		arkClient, err := arkclientset.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create ark client")
+		}
+
+		impl.recorder, err = manifest.NewRecorderFromEnv(impl.log, arkClient)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create quiesce manifest recorder")
+		}
+	}
+
+	// On SIGTERM/SIGINT — a pod restart, typically — finish in-flight items,
+	// undo any live scale-downs this process performed, and exit, so a
+	// restart mid-backup doesn't leave workloads scaled down.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		impl.log.Infof("Received %s, finishing in-flight items before exiting", sig)
+		impl.inFlight.Wait()
+		impl.notifier.Flush()
+
+		if impl.quiescer != nil {
+			if err := impl.quiescer.RollbackAll(); err != nil {
+				impl.log.WithError(err).Error("Error rolling back live quiesces")
+				os.Exit(1)
+			}
+		}
+		os.Exit(0)
+	}()
+
 	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
 }
 
+// runRBAC prints the ClusterRole and ClusterRoleBinding YAML the plugin
+// needs, generated from the same capability table validate-config checks.
+// The service account defaults to heptio-ark/ark and can be overridden
+// with positional namespace and name arguments.
+func runRBAC(args []string) int {
+	namespace, name := "heptio-ark", "ark"
+	if len(args) > 0 {
+		namespace = args[0]
+	}
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	manifests, err := validation.RBACManifests(namespace, name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	fmt.Print(string(manifests))
+	return 0
+}
+
+// runValidate checks that the plugin's configuration parses and, if live
+// quiesce is enabled, that the plugin's service account has the RBAC
+// permissions live quiesce needs. It returns the process exit code.
+func runValidate() int {
+	errs := validation.CheckConfig()
+
+	if os.Getenv(liveQuiesceEnvVar) == "true" {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s is set but unable to get in-cluster client config: %v", liveQuiesceEnvVar, err))
+		} else if kubeClient, err := kubernetes.NewForConfig(config); err != nil {
+			errs = append(errs, fmt.Errorf("unable to create kubernetes client: %v", err))
+		} else {
+			errs = append(errs, validation.CheckRBAC(kubeClient)...)
+			errs = append(errs, validation.CheckNamespaces(kubeClient)...)
+		}
+	}
+
+	if len(errs) == 0 {
+		fmt.Println("Configuration and permissions OK")
+		return 0
+	}
+
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+	}
+	return 1
+}
+
 // ScaleDeploymentsToZeroReplicas is a backup item action plugin for Heptio Ark.
 type ScaleDeploymentsToZeroReplicas struct {
-	log logrus.FieldLogger
+	log        logrus.FieldLogger
+	inFlight   sync.WaitGroup
+	quiescer   *quiesce.Quiescer
+	kubeClient kubernetes.Interface
+	recorder   *manifest.Recorder
+	reporter   *report.Reporter
+	notifier   *notify.Notifier
+	tracer     *trace.Tracer
+
+	// policy holds a *policyConfig; it's swapped wholesale when the
+	// policy ConfigMap changes, so in-flight items see a consistent
+	// snapshot.
+	policy atomic.Value
+
+	// clusterName and clusterUID identify the source cluster; mutated
+	// items are stamped with them so cross-cluster restores can adapt.
+	clusterName string
+	clusterUID  string
 }
 
-// AppliesTo returns a backup.ResourceSelector that applies to deployments only.
+// policyConfig is the hot-reloadable slice of the plugin's configuration:
+// everything that can change between backups without needing new clients
+// or RBAC.
+type policyConfig struct {
+	persistenceMode config.PersistenceMode
+	errorPolicies   *config.ErrorPolicies
+	includeRBAC     bool
+	includeServices bool
+	gitopsMode      bool
+
+	// filterExpression and targetReplicasExpression are optional
+	// expressions giving per-item control beyond static label selectors;
+	// see pkg/config for the supported grammar.
+	filterExpression         *config.Expression
+	targetReplicasExpression *config.Expression
+}
+
+// loadPolicyConfig derives a policyConfig from the current environment.
+func loadPolicyConfig() (*policyConfig, error) {
+	policy := &policyConfig{
+		includeRBAC:     os.Getenv(includeRBACEnvVar) == "true",
+		includeServices: os.Getenv(includeServicesEnvVar) == "true",
+		gitopsMode:      os.Getenv(gitopsModeEnvVar) == "true",
+	}
+
+	var err error
+	if policy.persistenceMode, err = config.LoadPersistenceMode(); err != nil {
+		return nil, err
+	}
+	if policy.errorPolicies, err = config.LoadErrorPolicies(); err != nil {
+		return nil, err
+	}
+	if policy.filterExpression, err = config.LoadFilterExpression(); err != nil {
+		return nil, err
+	}
+	if policy.targetReplicasExpression, err = config.LoadTargetReplicasExpression(); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// currentPolicy returns the active policy snapshot.
+func (p *ScaleDeploymentsToZeroReplicas) currentPolicy() *policyConfig {
+	return p.policy.Load().(*policyConfig)
+}
+
+// decisionLogKey marks a log entry as a per-item decision record. The
+// plugin logger is forwarded into the backup's log file in object storage,
+// so `ark backup logs <backup> | grep pluginDecision` shows exactly what
+// the plugin did to each item without node access.
+const decisionLogKey = "pluginDecision"
+
+// logDecision emits one structured record describing what the plugin did
+// to an item and why, and mirrors it onto the backup's ScaleActionReport
+// if reporting is enabled.
+func (p *ScaleDeploymentsToZeroReplicas) logDecision(item runtime.Unstructured, arkBackup *v1.Backup, decision, reason string, extra logrus.Fields) {
+	fields := logrus.Fields{
+		decisionLogKey: decision,
+		"reason":       reason,
+	}
+	entry := report.Entry{
+		Decision:  decision,
+		Reason:    reason,
+		Timestamp: time.Now(),
+	}
+	if metadata, err := meta.Accessor(item); err == nil {
+		fields["namespace"] = metadata.GetNamespace()
+		fields["name"] = metadata.GetName()
+		entry.Namespace = metadata.GetNamespace()
+		entry.Name = metadata.GetName()
+	}
+	for key, val := range extra {
+		fields[key] = val
+	}
+	if errVal, ok := extra["error"].(string); ok {
+		entry.Error = errVal
+	}
+
+	p.log.WithFields(fields).Info("Backup item decision")
+	p.reporter.Record(arkBackup, entry)
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to deployments by
+// default; the environment can override the resources, namespaces, and label
+// selector.
 func (p *ScaleDeploymentsToZeroReplicas) AppliesTo() (backup.ResourceSelector, error) {
-	return backup.ResourceSelector{
-		IncludedResources: []string{"deployments.apps"},
-	}, nil
+	return config.BackupSelectorFromEnv("deployments.apps")
 }
 
-// Execute sets .spec.replicas to 0.
+// Execute sets .spec.replicas to 0. In live quiesce mode it also annotates
+// the deployment's pods with backup traceability info and scales the live
+// deployment down. Errors are handled per the configured error policy: by
+// default they fail the item, but the operator can degrade them to a
+// warning or skip the item with an annotation, globally or per namespace.
 func (p *ScaleDeploymentsToZeroReplicas) Execute(item runtime.Unstructured, backup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.inFlight.Add(1)
+	defer p.inFlight.Done()
+
+	updatedItem, additionalItems, err := p.execute(item, backup)
+	if err == nil {
+		return updatedItem, additionalItems, nil
+	}
+
+	metadata, metaErr := meta.Accessor(item)
+	if metaErr != nil {
+		return nil, nil, err
+	}
+
+	switch p.currentPolicy().errorPolicies.ForNamespace(metadata.GetNamespace()) {
+	case config.ErrorPolicyWarn:
+		p.log.WithError(err).Warnf("Error processing deployment %s/%s, backing it up unmodified", metadata.GetNamespace(), metadata.GetName())
+		p.logDecision(item, backup, "unmodified", "error under warn policy", logrus.Fields{"error": err.Error()})
+		return item, nil, nil
+	case config.ErrorPolicySkip:
+		p.log.WithError(err).Warnf("Error processing deployment %s/%s, skipping it", metadata.GetNamespace(), metadata.GetName())
+		p.logDecision(item, backup, "skipped", "error under skip policy", logrus.Fields{"error": err.Error()})
+		annotations := metadata.GetAnnotations()
+		if annotations == nil {
+			annotations = make(map[string]string)
+		}
+		annotations[skippedDueToErrorAnnotation] = err.Error()
+		metadata.SetAnnotations(annotations)
+		return item, nil, nil
+	default:
+		return nil, nil, err
+	}
+}
+
+func (p *ScaleDeploymentsToZeroReplicas) execute(item runtime.Unstructured, arkBackup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
 	p.log.Info("Running ScaleDeploymentsToZeroReplicas backup item action")
 	defer p.log.Info("Done running ScaleDeploymentsToZeroReplicas backup item action")
 
-	if err := unstructured.SetNestedField(item.UnstructuredContent(), int64(0), "spec", "replicas"); err != nil {
-		p.log.WithError(err).Error("Error setting .spec.replicase")
+	policy := p.currentPolicy()
+
+	ctx, span := p.tracer.StartSpan(context.Background(), "ScaleDeploymentsToZeroReplicas.Execute")
+	span.SetAttribute("backup", arkBackup.Name)
+	if metadata, err := meta.Accessor(item); err == nil {
+		span.SetAttribute("namespace", metadata.GetNamespace())
+		span.SetAttribute("name", metadata.GetName())
+	}
+	defer span.End()
+
+	profile, err := config.ProfileForBackup(arkBackup)
+	if err != nil {
 		return nil, nil, err
 	}
 
-	return item, nil, nil
+	if !profile.HandlesKind("deployments.apps") {
+		p.logDecision(item, arkBackup, "unmodified", "quiesce profile doesn't cover deployments", nil)
+		return item, nil, nil
+	}
+
+	if itemMeta, err := meta.Accessor(item); err == nil {
+		if skip, release := config.LoadHelmPolicy().ShouldSkip(itemMeta.GetLabels()); skip {
+			p.logDecision(item, arkBackup, "unmodified", "belongs to a Helm release", logrus.Fields{"release": release})
+			return item, nil, nil
+		}
+	}
+
+	if policy.filterExpression != nil {
+		match, err := policy.filterExpression.EvalBool(item.UnstructuredContent())
+		if err != nil {
+			return nil, nil, err
+		}
+		if !match {
+			p.logDecision(item, arkBackup, "unmodified", "doesn't match the filter expression", nil)
+			return item, nil, nil
+		}
+	}
+
+	// Record the original replica count so the scale-restore controller can
+	// scale the workload back up after a restore.
+	replicas, found, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.replicas")
+	}
+
+	// If this process already quiesced the workload as a dependent of an
+	// earlier item, the item was read after the scale-down — use the
+	// replica count recorded at quiesce time instead.
+	if p.quiescer != nil {
+		if metadata, err := meta.Accessor(item); err == nil {
+			if pre, ok := p.quiescer.PreQuiescedReplicas(metadata.GetNamespace(), metadata.GetName()); ok {
+				replicas = pre
+				found = true
+			}
+		}
+	}
+
+	if found && p.recorder != nil {
+		if metadata, err := meta.Accessor(item); err == nil {
+			p.recorder.Record(arkBackup, manifest.Item{
+				GroupResource:    "deployments.apps",
+				Namespace:        metadata.GetNamespace(),
+				Name:             metadata.GetName(),
+				OriginalReplicas: replicas,
+			})
+		}
+	}
+
+	var additionalItems []backup.ResourceIdentifier
+	if found {
+		switch policy.persistenceMode {
+		case config.PersistenceModeConfigMap:
+			additionalItems, err = p.recordReplicasInConfigMap(item, arkBackup, replicas)
+			if err != nil {
+				return nil, nil, err
+			}
+		default:
+			metadata, err := meta.Accessor(item)
+			if err != nil {
+				return nil, nil, errors.Wrap(err, "unable to access deployment metadata")
+			}
+
+			annotations := metadata.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			annotations[controller.OriginalReplicasAnnotation] = strconv.FormatInt(replicas, 10)
+			metadata.SetAnnotations(annotations)
+		}
+	}
+
+	targetReplicas := profile.TargetReplicas
+	if policy.targetReplicasExpression != nil {
+		targetReplicas, err = policy.targetReplicasExpression.EvalInt(item.UnstructuredContent())
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if metadata, err := meta.Accessor(item); err == nil && metadata.GetLabels()[haCriticalLabel] == "true" {
+		if haTarget := haTargetReplicas(item, replicas); haTarget > targetReplicas {
+			targetReplicas = haTarget
+			p.log.Infof("Deployment %s/%s is HA-critical, scaling to %d replicas instead of %d",
+				metadata.GetNamespace(), metadata.GetName(), targetReplicas, profile.TargetReplicas)
+		}
+	}
+
+	if p.quiescer != nil && profile.LiveQuiesce {
+		permitted, err := config.LiveQuiescePermitted(time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if !permitted {
+			p.log.Info("Outside the configured quiesce windows, falling back to archive-only mutation")
+		} else if err := p.quiescer.QuiesceDeployment(ctx, item, arkBackup, targetReplicas, profile.WaitForTermination); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if p.clusterName != "" || p.clusterUID != "" {
+		if metadata, err := meta.Accessor(item); err == nil {
+			annotations := metadata.GetAnnotations()
+			if annotations == nil {
+				annotations = make(map[string]string)
+			}
+			if p.clusterName != "" {
+				annotations[config.SourceClusterNameAnnotation] = p.clusterName
+			}
+			if p.clusterUID != "" {
+				annotations[config.SourceClusterUIDAnnotation] = p.clusterUID
+			}
+			metadata.SetAnnotations(annotations)
+		}
+	}
+
+	_, mutateSpan := p.tracer.StartSpan(ctx, "mutate")
+	if policy.gitopsMode {
+		unstructured.RemoveNestedField(item.UnstructuredContent(), "spec", "replicas")
+	} else {
+		err = unstructured.SetNestedField(item.UnstructuredContent(), targetReplicas, "spec", "replicas")
+	}
+	mutateSpan.End()
+	if err != nil {
+		p.log.WithError(err).Error("Error setting .spec.replicas")
+		return nil, nil, err
+	}
+
+	decisionFields := logrus.Fields{"liveQuiesce": p.quiescer != nil && profile.LiveQuiesce}
+	if found {
+		decisionFields["originalReplicas"] = replicas
+	}
+	if policy.gitopsMode {
+		p.logDecision(item, arkBackup, "replicas-removed", "gitops mode", decisionFields)
+	} else {
+		decisionFields["targetReplicas"] = targetReplicas
+		p.logDecision(item, arkBackup, "scaled", "quiesce profile", decisionFields)
+	}
+
+	if policy.includeRBAC && p.kubeClient != nil {
+		rbacItems, err := p.rbacAdditionalItems(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		additionalItems = append(additionalItems, rbacItems...)
+	}
+
+	if policy.includeServices && p.kubeClient != nil {
+		serviceItems, err := p.serviceAdditionalItems(item)
+		if err != nil {
+			return nil, nil, err
+		}
+		additionalItems = append(additionalItems, serviceItems...)
+	}
+
+	return item, additionalItems, nil
+}
+
+// serviceAdditionalItems returns Services in the deployment's namespace
+// whose selectors match its pod template labels, so they're backed up
+// alongside the workload. Selectorless Services never match.
+func (p *ScaleDeploymentsToZeroReplicas) serviceAdditionalItems(item runtime.Unstructured) ([]backup.ResourceIdentifier, error) {
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to access deployment metadata")
+	}
+	namespace := metadata.GetNamespace()
+
+	podLabels, found, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "template", "metadata", "labels")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .spec.template.metadata.labels")
+	}
+	if !found {
+		return nil, nil
+	}
+
+	services, err := p.kubeClient.CoreV1().Services(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing services in namespace %s", namespace)
+	}
+
+	var additionalItems []backup.ResourceIdentifier
+	for i := range services.Items {
+		service := &services.Items[i]
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+
+		if !labels.SelectorFromSet(labels.Set(service.Spec.Selector)).Matches(labels.Set(podLabels)) {
+			continue
+		}
+
+		additionalItems = append(additionalItems, backup.ResourceIdentifier{
+			GroupResource: schema.GroupResource{Resource: "services"},
+			Namespace:     namespace,
+			Name:          service.Name,
+		})
+	}
+
+	return additionalItems, nil
+}
+
+// rbacAdditionalItems returns the deployment's ServiceAccount and the
+// Role/RoleBindings binding to it, so they're backed up alongside the
+// workload. The auto-created default ServiceAccount is left out.
+func (p *ScaleDeploymentsToZeroReplicas) rbacAdditionalItems(item runtime.Unstructured) ([]backup.ResourceIdentifier, error) {
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to access deployment metadata")
+	}
+	namespace := metadata.GetNamespace()
+
+	serviceAccount, _, err := unstructured.NestedString(item.UnstructuredContent(), "spec", "template", "spec", "serviceAccountName")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .spec.template.spec.serviceAccountName")
+	}
+	if serviceAccount == "" || serviceAccount == "default" {
+		return nil, nil
+	}
+
+	additionalItems := []backup.ResourceIdentifier{
+		{
+			GroupResource: schema.GroupResource{Resource: "serviceaccounts"},
+			Namespace:     namespace,
+			Name:          serviceAccount,
+		},
+	}
+
+	roleBindings, err := p.kubeClient.RbacV1().RoleBindings(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing rolebindings in namespace %s", namespace)
+	}
+
+	for i := range roleBindings.Items {
+		binding := &roleBindings.Items[i]
+
+		bindsServiceAccount := false
+		for _, subject := range binding.Subjects {
+			if subject.Kind == rbacv1.ServiceAccountKind && subject.Name == serviceAccount &&
+				(subject.Namespace == "" || subject.Namespace == namespace) {
+				bindsServiceAccount = true
+				break
+			}
+		}
+		if !bindsServiceAccount {
+			continue
+		}
+
+		additionalItems = append(additionalItems, backup.ResourceIdentifier{
+			GroupResource: schema.GroupResource{Group: rbacv1.GroupName, Resource: "rolebindings"},
+			Namespace:     namespace,
+			Name:          binding.Name,
+		})
+
+		if binding.RoleRef.Kind == "Role" {
+			additionalItems = append(additionalItems, backup.ResourceIdentifier{
+				GroupResource: schema.GroupResource{Group: rbacv1.GroupName, Resource: "roles"},
+				Namespace:     namespace,
+				Name:          binding.RoleRef.Name,
+			})
+		}
+	}
+
+	return additionalItems, nil
+}
+
+// haTargetReplicas returns max(1, replicas - maxUnavailable) for an
+// HA-critical deployment, deriving maxUnavailable from the deployment's
+// rolling update strategy (defaulting to 25%, matching kubernetes).
+func haTargetReplicas(item runtime.Unstructured, replicas int64) int64 {
+	maxUnavailable := int64(replicas * 25 / 100)
+
+	val, found, err := unstructured.NestedFieldCopy(item.UnstructuredContent(), "spec", "strategy", "rollingUpdate", "maxUnavailable")
+	if err == nil && found {
+		switch v := val.(type) {
+		case int64:
+			maxUnavailable = v
+		case string:
+			var percent int64
+			if _, err := fmt.Sscanf(v, "%d%%", &percent); err == nil {
+				maxUnavailable = replicas * percent / 100
+			}
+		}
+	}
+
+	target := replicas - maxUnavailable
+	if target < 1 {
+		target = 1
+	}
+	return target
+}
+
+// recordReplicasInConfigMap writes the deployment's original replica count
+// into the backup's per-namespace ConfigMap and returns the ConfigMap as an
+// additional item so it's included in the backup.
+func (p *ScaleDeploymentsToZeroReplicas) recordReplicasInConfigMap(item runtime.Unstructured, arkBackup *v1.Backup, replicas int64) ([]backup.ResourceIdentifier, error) {
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to access deployment metadata")
+	}
+
+	var (
+		namespace = metadata.GetNamespace()
+		name      = metadata.GetName()
+		cmName    = "ark-scale-original-replicas-" + arkBackup.Name
+		cmClient  = p.kubeClient.CoreV1().ConfigMaps(namespace)
+	)
+
+	cm, err := cmClient.Get(cmName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: namespace,
+				Name:      cmName,
+				Labels: map[string]string{
+					"scale-deployment.ark.heptio.com/backup": arkBackup.Name,
+				},
+			},
+			Data: map[string]string{
+				name: strconv.FormatInt(replicas, 10),
+			},
+		}
+		if _, err := cmClient.Create(cm); err != nil {
+			return nil, errors.Wrapf(err, "error creating configmap %s/%s", namespace, cmName)
+		}
+	case err != nil:
+		return nil, errors.Wrapf(err, "error getting configmap %s/%s", namespace, cmName)
+	default:
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[name] = strconv.FormatInt(replicas, 10)
+		if _, err := cmClient.Update(cm); err != nil {
+			return nil, errors.Wrapf(err, "error updating configmap %s/%s", namespace, cmName)
+		}
+	}
+
+	return []backup.ResourceIdentifier{
+		{
+			GroupResource: schema.GroupResource{Resource: "configmaps"},
+			Namespace:     namespace,
+			Name:          cmName,
+		},
+	}, nil
 }
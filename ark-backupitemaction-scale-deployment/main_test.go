@@ -0,0 +1,43 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+
+	"github.com/skriss/ark-scale-deployment/pkg/conformance"
+)
+
+// newOfflineAction mirrors runMutate's setup: no cluster clients, default
+// policy from a clean environment.
+func newOfflineAction(t *testing.T) *ScaleDeploymentsToZeroReplicas {
+	policy, err := loadPolicyConfig()
+	if err != nil {
+		t.Fatalf("error loading default policy: %v", err)
+	}
+
+	impl := &ScaleDeploymentsToZeroReplicas{log: arktest.NewLogger()}
+	impl.policy.Store(policy)
+
+	return impl
+}
+
+func TestBackupConformance(t *testing.T) {
+	conformance.RunBackupConformance(t, newOfflineAction(t))
+}
@@ -0,0 +1,245 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	// originalSyncPolicyAnnotation holds the JSON-encoded
+	// .spec.syncPolicy.automated that an Application had before auto-sync
+	// was suspended for a quiescing backup.
+	originalSyncPolicyAnnotation = "scale-deployment.ark.heptio.com/original-sync-policy"
+
+	backupPollInterval = 10 * time.Second
+	backupPollTimeout  = 4 * time.Hour
+)
+
+func main() {
+	impl := &SuspendArgoCDApplications{
+		log: plugin.NewLogger(),
+	}
+
+	if clientConfig, err := rest.InClusterConfig(); err != nil {
+		impl.log.WithError(err).Info("No in-cluster client config, ArgoCD auto-sync will not be suspended")
+	} else {
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.clientPool = dynamic.NewDynamicClientPool(clientConfig)
+
+		impl.arkClient, err = arkclientset.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create ark client")
+		}
+	}
+
+	plugin.Serve(plugin.NewBackupItemActionPlugin(impl))
+}
+
+// SuspendArgoCDApplications is a backup item action plugin for Heptio Ark
+// that disables auto-sync on ArgoCD Applications while a quiescing backup is
+// in flight, so ArgoCD doesn't immediately revert the scale-down, and
+// re-enables it once the backup finishes.
+type SuspendArgoCDApplications struct {
+	log        logrus.FieldLogger
+	clientPool dynamic.ClientPool
+	arkClient  arkclientset.Interface
+}
+
+// AppliesTo returns a backup.ResourceSelector that applies to ArgoCD
+// Applications by default; the environment can override the resources,
+// namespaces, and label selector.
+func (p *SuspendArgoCDApplications) AppliesTo() (backup.ResourceSelector, error) {
+	return config.BackupSelectorFromEnv("applications.argoproj.io")
+}
+
+// Execute disables auto-sync on the live Application, records the original
+// sync policy in an annotation, and arranges for auto-sync to be re-enabled
+// when the backup finishes. The backed-up copy is not modified.
+func (p *SuspendArgoCDApplications) Execute(item runtime.Unstructured, arkBackup *v1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	p.log.Info("Running SuspendArgoCDApplications backup item action")
+	defer p.log.Info("Done running SuspendArgoCDApplications backup item action")
+
+	profile, err := config.ProfileForBackup(arkBackup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Auto-sync only fights the quiesce when the live workloads are scaled
+	// down, so there's nothing to suspend otherwise.
+	if !profile.LiveQuiesce || p.clientPool == nil {
+		return item, nil, nil
+	}
+
+	automated, found, err := unstructured.NestedMap(item.UnstructuredContent(), "spec", "syncPolicy", "automated")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.syncPolicy.automated")
+	}
+	if !found {
+		p.log.Info("application does not have auto-sync enabled, not modifying it")
+		return item, nil, nil
+	}
+
+	namespace, _, err := unstructured.NestedString(item.UnstructuredContent(), "metadata", "namespace")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .metadata.namespace")
+	}
+	name, _, err := unstructured.NestedString(item.UnstructuredContent(), "metadata", "name")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .metadata.name")
+	}
+	apiVersion, _, err := unstructured.NestedString(item.UnstructuredContent(), "apiVersion")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .apiVersion")
+	}
+
+	client, err := p.applicationClient(apiVersion, namespace)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originalPolicy, err := json.Marshal(automated)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error encoding original sync policy")
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				originalSyncPolicyAnnotation: string(originalPolicy),
+			},
+		},
+		"spec": map[string]interface{}{
+			"syncPolicy": map[string]interface{}{
+				"automated": nil,
+			},
+		},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error encoding patch")
+	}
+
+	if _, err := client.Patch(name, types.MergePatchType, patch); err != nil {
+		return nil, nil, errors.Wrapf(err, "error suspending auto-sync on application %s/%s", namespace, name)
+	}
+
+	p.log.Infof("Suspended auto-sync on application %s/%s for backup %s", namespace, name, arkBackup.Name)
+
+	go p.resumeWhenBackupFinishes(arkBackup.Namespace, arkBackup.Name, apiVersion, namespace, name, automated)
+
+	return item, nil, nil
+}
+
+// resumeWhenBackupFinishes polls the Backup until it reaches a terminal
+// phase, then restores the Application's original auto-sync policy and
+// removes the bookkeeping annotation.
+func (p *SuspendArgoCDApplications) resumeWhenBackupFinishes(backupNamespace, backupName, apiVersion, namespace, name string, automated map[string]interface{}) {
+	log := p.log.WithField("application", namespace+"/"+name).WithField("backup", backupName)
+
+	err := wait.PollImmediate(backupPollInterval, backupPollTimeout, func() (bool, error) {
+		backup, err := p.arkClient.ArkV1().Backups(backupNamespace).Get(backupName, metav1.GetOptions{})
+		if err != nil {
+			log.WithError(err).Warn("Error getting backup, will retry")
+			return false, nil
+		}
+
+		switch backup.Status.Phase {
+		case v1.BackupPhaseCompleted, v1.BackupPhaseFailed, v1.BackupPhaseFailedValidation:
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("Timed out waiting for backup to finish, not re-enabling auto-sync")
+		return
+	}
+
+	client, err := p.applicationClient(apiVersion, namespace)
+	if err != nil {
+		log.WithError(err).Error("Error getting application client, not re-enabling auto-sync")
+		return
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				originalSyncPolicyAnnotation: nil,
+			},
+		},
+		"spec": map[string]interface{}{
+			"syncPolicy": map[string]interface{}{
+				"automated": automated,
+			},
+		},
+	})
+	if err != nil {
+		log.WithError(err).Error("Error encoding patch, not re-enabling auto-sync")
+		return
+	}
+
+	if _, err := client.Patch(name, types.MergePatchType, patch); err != nil {
+		log.WithError(err).Error("Error re-enabling auto-sync")
+		return
+	}
+
+	log.Info("Re-enabled auto-sync")
+}
+
+// applicationClient returns a dynamic client for ArgoCD Applications in the
+// given namespace.
+func (p *SuspendArgoCDApplications) applicationClient(apiVersion, namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing apiVersion %q", apiVersion)
+	}
+
+	client, err := p.clientPool.ClientForGroupVersionKind(gv.WithKind(""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting dynamic client for %s", gv)
+	}
+
+	apiResource := &metav1.APIResource{
+		Name:       "applications",
+		Namespaced: true,
+	}
+
+	return client.Resource(apiResource, namespace), nil
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	arkinformers "github.com/heptio/ark/pkg/generated/informers/externalversions"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/controller"
+)
+
+func main() {
+	workers := pflag.Int("workers", 2, "number of concurrent restore processors")
+	pflag.Parse()
+
+	log := plugin.NewLogger()
+
+	clientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		log.WithError(err).Fatal("Unable to get in-cluster client config")
+	}
+	if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+		log.WithError(err).Fatal("Invalid client config overrides")
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create kubernetes client")
+	}
+
+	arkClient, err := arkclientset.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create ark client")
+	}
+
+	factory := arkinformers.NewSharedInformerFactory(arkClient, 5*time.Minute)
+
+	c := controller.NewScaleRestoreController(
+		log,
+		kubeClient,
+		dynamic.NewDynamicClientPool(clientConfig),
+		factory.Ark().V1().Restores(),
+	)
+
+	stopCh := make(chan struct{})
+	factory.Start(stopCh)
+
+	if err := c.Run(*workers, stopCh); err != nil {
+		log.WithError(err).Fatal("Error running scale-restore controller")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	// volumeSnapshotAnnotation is set by the csi-snapshot backup item action
+	// and holds the name of the VolumeSnapshot taken for the claim.
+	volumeSnapshotAnnotation = "scale-deployment.ark.heptio.com/csi-volume-snapshot"
+
+	snapshotAPIGroup = "snapshot.storage.k8s.io"
+)
+
+func main() {
+	impl := &RestoreFromCSISnapshots{
+		log: plugin.NewLogger(),
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// RestoreFromCSISnapshots is a restore item action plugin for Heptio Ark
+// that points restored PersistentVolumeClaims at the VolumeSnapshot the
+// csi-snapshot backup item action took, so the CSI provisioner populates
+// the replacement volume from the snapshot rather than provisioning it
+// empty.
+type RestoreFromCSISnapshots struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to
+// PersistentVolumeClaims by default; the environment can override the
+// resources, namespaces, and label selector.
+func (p *RestoreFromCSISnapshots) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("persistentvolumeclaims")
+}
+
+// Execute sets the claim's dataSource to the VolumeSnapshot recorded at
+// backup time and clears its binding to the original volume. Claims backed
+// up without a snapshot, and restores with PV restoration disabled, are
+// left unchanged.
+func (p *RestoreFromCSISnapshots) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running RestoreFromCSISnapshots restore item action")
+	defer p.log.Info("Done running RestoreFromCSISnapshots restore item action")
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access persistentvolumeclaim metadata")
+	}
+
+	snapshotName := metadata.GetAnnotations()[volumeSnapshotAnnotation]
+	if snapshotName == "" {
+		return obj, nil, nil
+	}
+
+	if restore.Spec.RestorePVs != nil && !*restore.Spec.RestorePVs {
+		p.log.Info("Restore has PV restoration disabled, not restoring claim from snapshot")
+		return obj, nil, nil
+	}
+
+	dataSource := map[string]interface{}{
+		"apiGroup": snapshotAPIGroup,
+		"kind":     "VolumeSnapshot",
+		"name":     snapshotName,
+	}
+	if err := unstructured.SetNestedMap(obj.UnstructuredContent(), dataSource, "spec", "dataSource"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.dataSource")
+	}
+
+	// clear the binding to the original volume so the CSI provisioner
+	// creates a new one from the snapshot.
+	unstructured.RemoveNestedField(obj.UnstructuredContent(), "spec", "volumeName")
+
+	p.log.Infof("Restoring claim %s/%s from volumesnapshot %s", metadata.GetNamespace(), metadata.GetName(), snapshotName)
+
+	return obj, nil, nil
+}
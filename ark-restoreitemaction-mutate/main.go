@@ -0,0 +1,72 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/mutation"
+)
+
+func main() {
+	log := plugin.NewLogger()
+
+	engine, err := mutation.NewEngineFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid mutation rules")
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(&MutateRestoredItems{
+		log:    log,
+		engine: engine,
+	}))
+}
+
+// MutateRestoredItems is a restore item action plugin for Heptio Ark that
+// applies the restore patches of the configured patch rules — typically
+// the inverse of the backup patches — to restored items.
+type MutateRestoredItems struct {
+	log    logrus.FieldLogger
+	engine *mutation.Engine
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to deployments
+// by default, matching the default rule set; the environment can override
+// the resources, namespaces, and label selector.
+func (p *MutateRestoredItems) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("deployments.apps")
+}
+
+// Execute applies the restore patches of all rules matching the item's
+// GroupKind.
+func (p *MutateRestoredItems) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running MutateRestoredItems restore item action")
+	defer p.log.Info("Done running MutateRestoredItems restore item action")
+
+	if err := p.engine.ApplyRestore(obj); err != nil {
+		return nil, nil, err
+	}
+
+	return obj, nil, nil
+}
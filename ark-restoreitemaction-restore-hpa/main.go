@@ -0,0 +1,223 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	// originalHPASpecAnnotation is set by the neutralize-hpa backup item
+	// action and holds the HPA's original settings as JSON.
+	originalHPASpecAnnotation = "scale-deployment.ark.heptio.com/original-hpa-spec"
+
+	availabilityPollInterval = 5 * time.Second
+	availabilityPollTimeout  = 10 * time.Minute
+)
+
+func main() {
+	impl := &RestoreHorizontalPodAutoscalers{
+		log: plugin.NewLogger(),
+	}
+
+	if clientConfig, err := rest.InClusterConfig(); err != nil {
+		impl.log.WithError(err).Info("No in-cluster client config, HPAs will be restored without waiting for target availability")
+	} else {
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create kubernetes client")
+		}
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// RestoreHorizontalPodAutoscalers is a restore item action plugin for Heptio
+// Ark that puts back the settings the neutralize-hpa backup item action
+// recorded, waiting for the HPA's target Deployment to become Available
+// first so the autoscaler doesn't react to transient restore-time metrics.
+type RestoreHorizontalPodAutoscalers struct {
+	log        logrus.FieldLogger
+	kubeClient kubernetes.Interface
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to
+// HorizontalPodAutoscalers by default; the environment can override the
+// resources, namespaces, and label selector.
+func (p *RestoreHorizontalPodAutoscalers) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("horizontalpodautoscalers.autoscaling")
+}
+
+// hpaSpecFields are the HPA settings the neutralize-hpa backup item
+// action records and pins; they're replaced wholesale on restore, so
+// fields that weren't present originally are removed.
+var hpaSpecFields = []string{"minReplicas", "maxReplicas", "metrics", "targetCPUUtilizationPercentage"}
+
+// Execute restores the HPA settings recorded at backup time. With a
+// cluster client available, the HPA is restored still neutralized and the
+// original settings are patched back in once its target Deployment
+// reports Available, so the autoscaler doesn't react to transient
+// restore-time metrics and Ark's sequential restore isn't stalled behind
+// the wait; without one, the settings are restored directly.
+func (p *RestoreHorizontalPodAutoscalers) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running RestoreHorizontalPodAutoscalers restore item action")
+	defer p.log.Info("Done running RestoreHorizontalPodAutoscalers restore item action")
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access horizontalpodautoscaler metadata")
+	}
+
+	annotations := metadata.GetAnnotations()
+	originalJSON, ok := annotations[originalHPASpecAnnotation]
+	if !ok {
+		p.log.Info("horizontalpodautoscaler has no original-hpa-spec annotation, not modifying it")
+		return obj, nil, nil
+	}
+
+	delete(annotations, originalHPASpecAnnotation)
+	metadata.SetAnnotations(annotations)
+
+	var original map[string]interface{}
+	if err := json.Unmarshal([]byte(originalJSON), &original); err != nil {
+		return obj, errors.Wrapf(err, "error parsing %s annotation", originalHPASpecAnnotation), nil
+	}
+
+	if p.kubeClient == nil {
+		if err := applyOriginalSpec(obj, original); err != nil {
+			return nil, nil, err
+		}
+		return obj, nil, nil
+	}
+
+	targetKind, _, err := unstructured.NestedString(obj.UnstructuredContent(), "spec", "scaleTargetRef", "kind")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.scaleTargetRef.kind")
+	}
+	targetName, _, err := unstructured.NestedString(obj.UnstructuredContent(), "spec", "scaleTargetRef", "name")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.scaleTargetRef.name")
+	}
+
+	// The wait and the live patch must use the namespace the item is being
+	// restored into, which differs from the backed-up one when the restore
+	// maps namespaces.
+	namespace := metadata.GetNamespace()
+	if mapped, ok := restore.Spec.NamespaceMapping[namespace]; ok {
+		namespace = mapped
+	}
+
+	p.log.Infof("Restoring horizontalpodautoscaler %s/%s neutralized, will restore its settings once its target is available", namespace, metadata.GetName())
+	go p.restoreWhenTargetAvailable(namespace, metadata.GetName(), targetKind, targetName, original)
+
+	return obj, nil, nil
+}
+
+// applyOriginalSpec replaces the neutralized HPA settings in the restored
+// copy with the recorded originals.
+func applyOriginalSpec(obj runtime.Unstructured, original map[string]interface{}) error {
+	spec, found, err := unstructured.NestedMap(obj.UnstructuredContent(), "spec")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec")
+	}
+	if !found {
+		spec = make(map[string]interface{})
+	}
+
+	for _, field := range hpaSpecFields {
+		delete(spec, field)
+		if val, ok := original[field]; ok {
+			spec[field] = val
+		}
+	}
+
+	if err := unstructured.SetNestedMap(obj.UnstructuredContent(), spec, "spec"); err != nil {
+		return errors.Wrap(err, "error setting .spec")
+	}
+	return nil
+}
+
+// restoreWhenTargetAvailable waits for the HPA's target Deployment to
+// report the Available condition — giving up, but still restoring the
+// settings, after the timeout — then patches the recorded originals onto
+// the live HPA.
+func (p *RestoreHorizontalPodAutoscalers) restoreWhenTargetAvailable(namespace, name, targetKind, targetName string, original map[string]interface{}) {
+	log := p.log.WithField("horizontalpodautoscaler", namespace+"/"+name)
+
+	if targetKind == "Deployment" {
+		err := wait.PollImmediate(availabilityPollInterval, availabilityPollTimeout, func() (bool, error) {
+			deployment, err := p.kubeClient.AppsV1().Deployments(namespace).Get(targetName, metav1.GetOptions{})
+			if err != nil {
+				// The target may not have been restored yet.
+				return false, nil
+			}
+			for _, condition := range deployment.Status.Conditions {
+				if condition.Type == appsv1.DeploymentAvailable {
+					return condition.Status == corev1.ConditionTrue, nil
+				}
+			}
+			return false, nil
+		})
+		if err != nil {
+			log.Warnf("Timed out waiting for deployment %s/%s to become available, restoring HPA settings anyway", namespace, targetName)
+		}
+	}
+
+	// Null out fields the original didn't have so the neutralized values
+	// don't linger.
+	patchSpec := make(map[string]interface{}, len(hpaSpecFields))
+	for _, field := range hpaSpecFields {
+		patchSpec[field] = original[field]
+	}
+	patch, err := json.Marshal(map[string]interface{}{"spec": patchSpec})
+	if err != nil {
+		log.WithError(err).Error("Error encoding HPA settings patch")
+		return
+	}
+
+	if _, err := p.kubeClient.AutoscalingV1().HorizontalPodAutoscalers(namespace).Patch(name, types.MergePatchType, patch); err != nil {
+		log.WithError(err).Error("Error restoring HPA settings")
+		return
+	}
+
+	log.Info("Restored horizontalpodautoscaler settings")
+}
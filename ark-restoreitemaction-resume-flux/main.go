@@ -0,0 +1,239 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+const (
+	healthPollInterval = 5 * time.Second
+	healthPollTimeout  = 10 * time.Minute
+)
+
+func main() {
+	impl := &ResumeFluxResources{
+		log: plugin.NewLogger(),
+	}
+
+	if clientConfig, err := rest.InClusterConfig(); err != nil {
+		impl.log.WithError(err).Info("No in-cluster client config, Flux resources will be unsuspended without waiting for workload health")
+	} else {
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.clientPool = dynamic.NewDynamicClientPool(clientConfig)
+
+		impl.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create kubernetes client")
+		}
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// ResumeFluxResources is a restore item action plugin for Heptio Ark that
+// unsuspends restored Flux resources once the workloads in their target
+// namespace are healthy, so GitOps reconciliation resumes automatically
+// post-restore without fighting the staged scale-up.
+type ResumeFluxResources struct {
+	log        logrus.FieldLogger
+	clientPool dynamic.ClientPool
+	kubeClient kubernetes.Interface
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to Flux
+// Kustomizations and HelmReleases by default; the environment can override
+// the resources, namespaces, and label selector.
+func (p *ResumeFluxResources) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv(
+		"kustomizations.kustomize.toolkit.fluxcd.io",
+		"helmreleases.helm.toolkit.fluxcd.io",
+	)
+}
+
+// Execute handles a suspended Flux resource: with a cluster client
+// available it's restored suspended and unsuspended live once the
+// deployments in its target namespace report Available; without one,
+// .spec.suspend is simply cleared in the restored copy.
+func (p *ResumeFluxResources) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running ResumeFluxResources restore item action")
+	defer p.log.Info("Done running ResumeFluxResources restore item action")
+
+	suspend, found, err := unstructured.NestedBool(obj.UnstructuredContent(), "spec", "suspend")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.suspend")
+	}
+	if !found || !suspend {
+		p.log.Info("resource is not suspended, not modifying it")
+		return obj, nil, nil
+	}
+
+	if p.clientPool == nil {
+		if err := unstructured.SetNestedField(obj.UnstructuredContent(), false, "spec", "suspend"); err != nil {
+			return nil, nil, errors.Wrap(err, "error setting .spec.suspend")
+		}
+		return obj, nil, nil
+	}
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access resource metadata")
+	}
+
+	apiVersion, _, err := unstructured.NestedString(obj.UnstructuredContent(), "apiVersion")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .apiVersion")
+	}
+	kind, _, err := unstructured.NestedString(obj.UnstructuredContent(), "kind")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .kind")
+	}
+
+	// Reconciliation targets spec.targetNamespace when set, the resource's
+	// own namespace otherwise. Both it and the resource's namespace must be
+	// translated through the restore's namespace mappings: the health poll
+	// and the live patch run against where things are restored to, not
+	// where they were backed up from.
+	targetNamespace, _, err := unstructured.NestedString(obj.UnstructuredContent(), "spec", "targetNamespace")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.targetNamespace")
+	}
+	if targetNamespace == "" {
+		targetNamespace = metadata.GetNamespace()
+	}
+	if mapped, ok := restore.Spec.NamespaceMapping[targetNamespace]; ok {
+		targetNamespace = mapped
+	}
+
+	namespace := metadata.GetNamespace()
+	if mapped, ok := restore.Spec.NamespaceMapping[namespace]; ok {
+		namespace = mapped
+	}
+
+	p.log.Infof("Restoring %s %s/%s suspended, will unsuspend once workloads in %s are healthy", kind, namespace, metadata.GetName(), targetNamespace)
+	go p.resumeWhenHealthy(apiVersion, kind, namespace, metadata.GetName(), targetNamespace)
+
+	return obj, nil, nil
+}
+
+// resumeWhenHealthy waits for every deployment in the target namespace to
+// report the Available condition, then clears .spec.suspend on the live
+// resource.
+func (p *ResumeFluxResources) resumeWhenHealthy(apiVersion, kind, namespace, name, targetNamespace string) {
+	log := p.log.WithField("resource", namespace+"/"+name)
+
+	err := wait.PollImmediate(healthPollInterval, healthPollTimeout, func() (bool, error) {
+		deployments, err := p.kubeClient.AppsV1().Deployments(targetNamespace).List(metav1.ListOptions{})
+		if err != nil {
+			log.WithError(err).Warn("Error listing deployments, will retry")
+			return false, nil
+		}
+
+		for i := range deployments.Items {
+			if !deploymentAvailable(&deployments.Items[i]) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.WithError(err).Error("Timed out waiting for workloads to become healthy, not unsuspending resource")
+		return
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		log.WithError(err).Error("Error parsing apiVersion, not unsuspending resource")
+		return
+	}
+
+	client, err := p.clientPool.ClientForGroupVersionKind(gv.WithKind(""))
+	if err != nil {
+		log.WithError(err).Error("Error getting dynamic client, not unsuspending resource")
+		return
+	}
+
+	apiResource, err := p.resourceFor(apiVersion, kind)
+	if err != nil {
+		log.WithError(err).Error("Error resolving resource, not unsuspending resource")
+		return
+	}
+
+	patch := []byte(`{"spec":{"suspend":false}}`)
+	if _, err := client.Resource(apiResource, namespace).Patch(name, types.MergePatchType, patch); err != nil {
+		log.WithError(err).Error("Error unsuspending resource")
+		return
+	}
+
+	log.Info("Unsuspended resource")
+}
+
+// resourceFor resolves the API resource for the given group/version and
+// kind via discovery — kinds with irregular plurals make guessing at the
+// resource name unsafe.
+func (p *ResumeFluxResources) resourceFor(apiVersion, kind string) (*metav1.APIResource, error) {
+	resourceList, err := p.kubeClient.Discovery().ServerResourcesForGroupVersion(apiVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error discovering resources for %s", apiVersion)
+	}
+
+	for i := range resourceList.APIResources {
+		resource := &resourceList.APIResources[i]
+		if resource.Kind == kind && !strings.Contains(resource.Name, "/") {
+			return resource, nil
+		}
+	}
+
+	return nil, errors.Errorf("no resource for %s kind %s", apiVersion, kind)
+}
+
+// deploymentAvailable reports whether the deployment's Available condition
+// is true; deployments with no conditions yet are not available.
+func deploymentAvailable(deployment *appsv1.Deployment) bool {
+	for _, condition := range deployment.Status.Conditions {
+		if condition.Type == appsv1.DeploymentAvailable {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
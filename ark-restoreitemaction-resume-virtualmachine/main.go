@@ -0,0 +1,93 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// originalRunningAnnotation is set by the pause-virtualmachine backup item
+// action and holds the value of .spec.running at backup time.
+const originalRunningAnnotation = "scale-deployment.ark.heptio.com/original-running"
+
+func main() {
+	impl := &ResumeVirtualMachines{
+		log: plugin.NewLogger(),
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// ResumeVirtualMachines is a restore item action plugin for Heptio Ark that
+// puts back the .spec.running value that the pause-virtualmachine backup
+// item action recorded.
+type ResumeVirtualMachines struct {
+	log logrus.FieldLogger
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to KubeVirt
+// VirtualMachines by default; the environment can override the resources,
+// namespaces, and label selector.
+func (p *ResumeVirtualMachines) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("virtualmachines.kubevirt.io")
+}
+
+// Execute restores .spec.running from the annotation recorded at backup
+// time, if present, and removes the annotation.
+func (p *ResumeVirtualMachines) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running ResumeVirtualMachines restore item action")
+	defer p.log.Info("Done running ResumeVirtualMachines restore item action")
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access virtualmachine metadata")
+	}
+
+	annotations := metadata.GetAnnotations()
+	original, ok := annotations[originalRunningAnnotation]
+	if !ok {
+		p.log.Info("virtualmachine has no original-running annotation, not modifying it")
+		return obj, nil, nil
+	}
+
+	delete(annotations, originalRunningAnnotation)
+	metadata.SetAnnotations(annotations)
+
+	running, err := strconv.ParseBool(original)
+	if err != nil {
+		return obj, errors.Wrapf(err, "error parsing %s annotation", originalRunningAnnotation), nil
+	}
+
+	if err := unstructured.SetNestedField(obj.UnstructuredContent(), running, "spec", "running"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.running")
+	}
+
+	return obj, nil, nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/controller"
+)
+
+// dryRunEnvVar puts the action in report-only mode: instead of changing
+// anything, it logs a structured per-item plan (current vs. intended
+// replicas, delays) into the restore log, so operators can rehearse a DR
+// runbook.
+const dryRunEnvVar = "ARK_SCALE_DRY_RUN"
+
+func main() {
+	impl := &RestoreDeploymentReplicas{
+		log:    plugin.NewLogger(),
+		dryRun: os.Getenv(dryRunEnvVar) == "true",
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// RestoreDeploymentReplicas is a restore item action plugin for Heptio Ark
+// that restores a deployment's replica count: from the original-replicas
+// annotation recorded at backup time when present, otherwise from the
+// per-namespace default replica map.
+type RestoreDeploymentReplicas struct {
+	log    logrus.FieldLogger
+	dryRun bool
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to deployments
+// by default; the environment can override the resources, namespaces, and
+// label selector.
+func (p *RestoreDeploymentReplicas) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("deployments.apps")
+}
+
+// Execute sets .spec.replicas from the original-replicas annotation, or the
+// namespace's configured default when no per-item override exists. When the
+// item was backed up from a different cluster than the one configured
+// locally, the namespace default takes precedence over the recorded count,
+// since source-cluster sizing rarely fits the destination.
+func (p *RestoreDeploymentReplicas) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running RestoreDeploymentReplicas restore item action")
+	defer p.log.Info("Done running RestoreDeploymentReplicas restore item action")
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access deployment metadata")
+	}
+
+	annotations := metadata.GetAnnotations()
+
+	crossCluster := false
+	if localCluster := os.Getenv(config.ClusterNameEnvVar); localCluster != "" {
+		if sourceCluster, ok := annotations[config.SourceClusterNameAnnotation]; ok && sourceCluster != localCluster {
+			p.log.Infof("Deployment %s/%s was backed up from cluster %q, restoring into %q", metadata.GetNamespace(), metadata.GetName(), sourceCluster, localCluster)
+			crossCluster = true
+		}
+	}
+
+	var (
+		intendedReplicas int64
+		source           string
+	)
+
+	if original, ok := annotations[controller.OriginalReplicasAnnotation]; ok && !crossCluster {
+		intendedReplicas, err = strconv.ParseInt(original, 10, 32)
+		if err != nil {
+			return obj, errors.Wrapf(err, "error parsing %s annotation", controller.OriginalReplicasAnnotation), nil
+		}
+		source = "original-replicas annotation"
+	} else {
+		defaults, err := config.LoadNamespaceReplicaDefaults()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		replicas, ok := defaults[metadata.GetNamespace()]
+		if !ok {
+			if p.dryRun {
+				p.log.WithFields(logrus.Fields{
+					"namespace": metadata.GetNamespace(),
+					"name":      metadata.GetName(),
+					"plan":      "no replica count recorded or defaulted, deployment would be restored as-is",
+				}).Info("Restore plan")
+			}
+			return obj, nil, nil
+		}
+		intendedReplicas = replicas
+		source = "namespace default"
+	}
+
+	if p.dryRun {
+		currentReplicas, _, _ := unstructured.NestedInt64(obj.UnstructuredContent(), "spec", "replicas")
+		p.log.WithFields(logrus.Fields{
+			"namespace":        metadata.GetNamespace(),
+			"name":             metadata.GetName(),
+			"currentReplicas":  currentReplicas,
+			"intendedReplicas": intendedReplicas,
+			"source":           source,
+			"restoreDelay":     annotations[controller.RestoreDelayAnnotation],
+		}).Info("Restore plan")
+		return obj, nil, nil
+	}
+
+	if source == "original-replicas annotation" {
+		delete(annotations, controller.OriginalReplicasAnnotation)
+		metadata.SetAnnotations(annotations)
+	}
+
+	if err := unstructured.SetNestedField(obj.UnstructuredContent(), intendedReplicas, "spec", "replicas"); err != nil {
+		return nil, nil, errors.Wrap(err, "error setting .spec.replicas")
+	}
+
+	p.log.Infof("Restored deployment %s/%s to %d replicas from %s", metadata.GetNamespace(), metadata.GetName(), intendedReplicas, source)
+	return obj, nil, nil
+}
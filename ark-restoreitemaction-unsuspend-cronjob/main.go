@@ -0,0 +1,147 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/plugin"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+)
+
+// resumeOffsetEnvVar delays unsuspending restored CronJobs by the given
+// duration (e.g. "30m"): the CronJob is restored suspended and a live
+// patch flips it back after the offset, so a restore doesn't trigger an
+// immediate burst of jobs. When unset, CronJobs are restored unsuspended.
+const resumeOffsetEnvVar = "ARK_SCALE_CRONJOB_RESUME_OFFSET"
+
+func main() {
+	impl := &UnsuspendCronJobs{
+		log: plugin.NewLogger(),
+	}
+
+	if val := os.Getenv(resumeOffsetEnvVar); val != "" {
+		offset, err := time.ParseDuration(val)
+		if err != nil {
+			impl.log.WithError(err).Fatalf("Invalid value %q for %s", val, resumeOffsetEnvVar)
+		}
+		impl.resumeOffset = offset
+
+		clientConfig, err := rest.InClusterConfig()
+		if err != nil {
+			impl.log.WithError(err).Fatalf("%s is set but unable to get in-cluster client config", resumeOffsetEnvVar)
+		}
+		if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+			impl.log.WithError(err).Fatal("Invalid client config overrides")
+		}
+
+		impl.kubeClient, err = kubernetes.NewForConfig(clientConfig)
+		if err != nil {
+			impl.log.WithError(err).Fatal("Unable to create kubernetes client")
+		}
+	}
+
+	plugin.Serve(plugin.NewRestoreItemActionPlugin(impl))
+}
+
+// UnsuspendCronJobs is a restore item action plugin for Heptio Ark that
+// unsuspends restored CronJobs, optionally after a configurable offset so
+// restores don't kick off a burst of jobs the moment they complete.
+type UnsuspendCronJobs struct {
+	log          logrus.FieldLogger
+	kubeClient   kubernetes.Interface
+	resumeOffset time.Duration
+}
+
+// AppliesTo returns a restore.ResourceSelector that applies to CronJobs by
+// default; the environment can override the resources, namespaces, and
+// label selector.
+func (p *UnsuspendCronJobs) AppliesTo() (restore.ResourceSelector, error) {
+	return config.RestoreSelectorFromEnv("cronjobs.batch")
+}
+
+// Execute unsuspends a suspended CronJob. With a resume offset configured,
+// the CronJob is restored suspended and unsuspended live once the offset
+// elapses.
+func (p *UnsuspendCronJobs) Execute(obj runtime.Unstructured, restore *v1.Restore) (runtime.Unstructured, error, error) {
+	p.log.Info("Running UnsuspendCronJobs restore item action")
+	defer p.log.Info("Done running UnsuspendCronJobs restore item action")
+
+	suspend, found, err := unstructured.NestedBool(obj.UnstructuredContent(), "spec", "suspend")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error getting .spec.suspend")
+	}
+	if !found || !suspend {
+		p.log.Info("cronjob is not suspended, not modifying it")
+		return obj, nil, nil
+	}
+
+	metadata, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to access cronjob metadata")
+	}
+
+	if p.resumeOffset == 0 {
+		if err := unstructured.SetNestedField(obj.UnstructuredContent(), false, "spec", "suspend"); err != nil {
+			return nil, nil, errors.Wrap(err, "error setting .spec.suspend")
+		}
+		p.log.Infof("Unsuspended cronjob %s/%s", metadata.GetNamespace(), metadata.GetName())
+		return obj, nil, nil
+	}
+
+	// The deferred live patch must target the namespace the CronJob is
+	// being restored into, not the one it was backed up from.
+	namespace := metadata.GetNamespace()
+	if mapped, ok := restore.Spec.NamespaceMapping[namespace]; ok {
+		namespace = mapped
+	}
+
+	p.log.Infof("Restoring cronjob %s/%s suspended, will unsuspend in %s", namespace, metadata.GetName(), p.resumeOffset)
+	go p.unsuspendAfterOffset(namespace, metadata.GetName())
+
+	return obj, nil, nil
+}
+
+// unsuspendAfterOffset flips .spec.suspend back to false on the live
+// CronJob once the resume offset elapses.
+func (p *UnsuspendCronJobs) unsuspendAfterOffset(namespace, name string) {
+	log := p.log.WithField("cronjob", namespace+"/"+name)
+
+	time.Sleep(p.resumeOffset)
+
+	patch := []byte(`{"spec":{"suspend":false}}`)
+	if _, err := p.kubeClient.BatchV1beta1().CronJobs(namespace).Patch(name, types.MergePatchType, patch); err != nil {
+		log.WithError(err).Error("Error unsuspending cronjob")
+		return
+	}
+
+	log.Info("Unsuspended cronjob")
+}
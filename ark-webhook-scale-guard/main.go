@@ -0,0 +1,41 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/spf13/pflag"
+
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/webhook"
+)
+
+func main() {
+	var (
+		addr     = pflag.String("listen-address", ":8443", "address to serve the webhook on")
+		certFile = pflag.String("tls-cert-file", "/var/run/scale-guard/tls.crt", "path to the TLS certificate")
+		keyFile  = pflag.String("tls-key-file", "/var/run/scale-guard/tls.key", "path to the TLS private key")
+	)
+	pflag.Parse()
+
+	log := plugin.NewLogger()
+
+	server := webhook.NewServer(log, *addr, *certFile, *keyFile)
+	if err := server.Run(); err != nil {
+		log.WithError(err).Fatal("Error running scale-guard webhook server")
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/spf13/pflag"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/webhook"
+)
+
+func main() {
+	var (
+		addr         = pflag.String("listen-address", ":8443", "address to serve the webhook on")
+		certFile     = pflag.String("tls-cert-file", "/var/run/spec-validator/tls.crt", "path to the TLS certificate")
+		keyFile      = pflag.String("tls-key-file", "/var/run/spec-validator/tls.key", "path to the TLS private key")
+		arkNamespace = pflag.String("ark-namespace", "heptio-ark", "namespace the Ark server's Config lives in")
+	)
+	pflag.Parse()
+
+	log := plugin.NewLogger()
+
+	clientConfig, err := rest.InClusterConfig()
+	if err != nil {
+		log.WithError(err).Fatal("Unable to get in-cluster client config")
+	}
+	if err := config.ApplyClientConfigOverrides(clientConfig); err != nil {
+		log.WithError(err).Fatal("Invalid client config overrides")
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create kubernetes client")
+	}
+
+	arkClient, err := arkclientset.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create ark client")
+	}
+
+	server := webhook.NewResourceValidator(log, *addr, *certFile, *keyFile, kubeClient.Discovery(), arkClient, *arkNamespace)
+	if err := server.Run(); err != nil {
+		log.WithError(err).Fatal("Error running spec-validator webhook server")
+	}
+}
@@ -0,0 +1,52 @@
+#!/usr/bin/env bash
+
+# Copyright 2018 the Heptio Ark contributors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Runs the plugin's end-to-end suite against a throwaway kind cluster:
+# builds the plugin image, stands up an Ark server with the plugin
+# registered, and runs test/e2e to assert backup/restore round-tripping.
+#
+# Requirements: docker, kind, kubectl, and an ark deployment manifest
+# (ARK_MANIFEST) pointing at an object store the cluster can reach.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+CLUSTER_NAME=${CLUSTER_NAME:-ark-scale-e2e}
+IMAGE=${IMAGE:-steveheptio/ark-scale-deployment:latest}
+ARK_MANIFEST=${ARK_MANIFEST:?set ARK_MANIFEST to an Ark server deployment manifest}
+KEEP_CLUSTER=${KEEP_CLUSTER:-}
+
+cleanup() {
+    if [[ -z "${KEEP_CLUSTER}" ]]; then
+        kind delete cluster --name "${CLUSTER_NAME}" || true
+    fi
+}
+trap cleanup EXIT
+
+kind create cluster --name "${CLUSTER_NAME}"
+KUBECONFIG=$(kind get kubeconfig-path --name "${CLUSTER_NAME}")
+export KUBECONFIG
+
+make container IMAGE="${IMAGE}"
+kind load docker-image --name "${CLUSTER_NAME}" "${IMAGE}"
+
+kubectl apply -f "${ARK_MANIFEST}"
+kubectl apply -f examples/scalerestore-crd.yaml
+
+kubectl -n heptio-ark rollout status deployment/ark --timeout=5m
+
+go run ./test/e2e --kubeconfig "${KUBECONFIG}" "$@"
@@ -0,0 +1,52 @@
+#!/usr/bin/env bash
+
+# Copyright 2018 the Heptio Ark contributors.
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+# Exports every commit in this repo that touches vendor/github.com/heptio/ark
+# as a patch series for the skriss/ark fork. The vendored tree is a mirror of
+# the fork's scale-deployment-0.9.3 branch (see the source override in
+# Gopkg.toml), so upstream changes belong there — including the *_test.go
+# files, which only run from the fork's own tree. To land the series:
+#
+#     hack/export-ark-patches.sh
+#     cd $GOPATH/src/github.com/heptio/ark   # remote: github.com/skriss/ark
+#     git checkout scale-deployment-0.9.3
+#     git am -p5 <this repo>/_output/ark-patches/*.patch
+#
+# then push the branch, run dep ensure here, and commit the re-vendored
+# result. -p5 strips the a/vendor/github.com/heptio/ark/ prefix so the
+# patches apply at the fork's root.
+
+set -o errexit
+set -o nounset
+set -o pipefail
+
+ARK_VENDOR_DIR=vendor/github.com/heptio/ark
+OUTPUT_DIR=${OUTPUT_DIR:-_output/ark-patches}
+
+cd "$(dirname "$0")/.."
+
+rm -rf "${OUTPUT_DIR}"
+mkdir -p "${OUTPUT_DIR}"
+
+# The root commit is the original vendoring of upstream 0.9.3, which the
+# fork's branch already contains; only export the commits after it.
+BASELINE=$(git rev-list --max-parents=0 HEAD)
+
+git format-patch --output-directory "${OUTPUT_DIR}" "${BASELINE}".. -- "${ARK_VENDOR_DIR}"
+
+echo
+echo "Wrote $(ls "${OUTPUT_DIR}" | wc -l) patches to ${OUTPUT_DIR}."
+echo "Apply to the skriss/ark scale-deployment-0.9.3 branch with: git am -p5 ${OUTPUT_DIR}/*.patch"
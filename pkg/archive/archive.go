@@ -0,0 +1,169 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package archive reads and rewrites Ark backup tarballs, whose items live
+// at resources/<group-resource>/namespaces/<namespace>/<name>.json (or
+// .../cluster/<name>.json for cluster-scoped resources).
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ItemPath identifies one item inside a backup archive.
+type ItemPath struct {
+	// GroupResource is the "resource.group" directory the item is under,
+	// e.g. "deployments.apps".
+	GroupResource string
+	// Namespace is empty for cluster-scoped items.
+	Namespace string
+	Name      string
+}
+
+// ParseItemPath parses an archive entry name into an ItemPath; ok is false
+// for entries that aren't items (metadata files, directories).
+func ParseItemPath(name string) (ItemPath, bool) {
+	parts := strings.Split(strings.TrimPrefix(name, "./"), "/")
+	if len(parts) < 4 || parts[0] != "resources" || !strings.HasSuffix(parts[len(parts)-1], ".json") {
+		return ItemPath{}, false
+	}
+
+	path := ItemPath{
+		GroupResource: parts[1],
+		Name:          strings.TrimSuffix(parts[len(parts)-1], ".json"),
+	}
+
+	switch {
+	case len(parts) == 5 && parts[2] == "namespaces":
+		path.Namespace = parts[3]
+	case len(parts) == 4 && parts[2] == "cluster":
+	default:
+		return ItemPath{}, false
+	}
+
+	return path, true
+}
+
+// Walk calls fn for every item in the gzipped tarball read from in.
+func Walk(in io.Reader, fn func(path ItemPath, item *unstructured.Unstructured) error) error {
+	gzr, err := gzip.NewReader(in)
+	if err != nil {
+		return errors.Wrap(err, "error reading gzip stream")
+	}
+	defer gzr.Close()
+
+	tarReader := tar.NewReader(gzr)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading tar stream")
+		}
+
+		path, ok := ParseItemPath(header.Name)
+		if !ok || header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		item := &unstructured.Unstructured{}
+		if err := json.NewDecoder(tarReader).Decode(&item.Object); err != nil {
+			return errors.Wrapf(err, "error parsing %s", header.Name)
+		}
+
+		if err := fn(path, item); err != nil {
+			return err
+		}
+	}
+}
+
+// Rewrite copies the gzipped tarball from in to out, passing every item
+// through transform. A transform returning nil leaves the item unchanged;
+// non-item entries are copied verbatim.
+func Rewrite(in io.Reader, out io.Writer, transform func(path ItemPath, item *unstructured.Unstructured) (*unstructured.Unstructured, error)) error {
+	gzr, err := gzip.NewReader(in)
+	if err != nil {
+		return errors.Wrap(err, "error reading gzip stream")
+	}
+	defer gzr.Close()
+
+	gzw := gzip.NewWriter(out)
+	defer gzw.Close()
+
+	tarReader := tar.NewReader(gzr)
+	tarWriter := tar.NewWriter(gzw)
+
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading tar stream")
+		}
+
+		path, isItem := ParseItemPath(header.Name)
+		if !isItem || header.Typeflag != tar.TypeReg {
+			if err := tarWriter.WriteHeader(header); err != nil {
+				return errors.Wrapf(err, "error writing %s", header.Name)
+			}
+			if _, err := io.Copy(tarWriter, tarReader); err != nil {
+				return errors.Wrapf(err, "error copying %s", header.Name)
+			}
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return errors.Wrapf(err, "error reading %s", header.Name)
+		}
+
+		item := &unstructured.Unstructured{}
+		if err := json.Unmarshal(data, &item.Object); err != nil {
+			return errors.Wrapf(err, "error parsing %s", header.Name)
+		}
+
+		transformed, err := transform(path, item)
+		if err != nil {
+			return errors.Wrapf(err, "error transforming %s", header.Name)
+		}
+		if transformed != nil {
+			if data, err = json.Marshal(transformed.UnstructuredContent()); err != nil {
+				return errors.Wrapf(err, "error encoding %s", header.Name)
+			}
+		}
+
+		header.Size = int64(len(data))
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "error writing %s", header.Name)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			return errors.Wrapf(err, "error writing %s", header.Name)
+		}
+	}
+
+	return errors.Wrap(tarWriter.Close(), "error finishing tar stream")
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestParseItemPath(t *testing.T) {
+	tests := []struct {
+		name         string
+		entry        string
+		expectedPath ItemPath
+		expectedOK   bool
+	}{
+		{
+			name:         "namespaced item",
+			entry:        "resources/deployments.apps/namespaces/ns-1/web.json",
+			expectedPath: ItemPath{GroupResource: "deployments.apps", Namespace: "ns-1", Name: "web"},
+			expectedOK:   true,
+		},
+		{
+			name:         "cluster-scoped item",
+			entry:        "resources/persistentvolumes/cluster/pv-1.json",
+			expectedPath: ItemPath{GroupResource: "persistentvolumes", Name: "pv-1"},
+			expectedOK:   true,
+		},
+		{
+			name:         "leading ./ is stripped",
+			entry:        "./resources/deployments.apps/namespaces/ns-1/web.json",
+			expectedPath: ItemPath{GroupResource: "deployments.apps", Namespace: "ns-1", Name: "web"},
+			expectedOK:   true,
+		},
+		{
+			name:       "metadata file is not an item",
+			entry:      "ark-backup.json",
+			expectedOK: false,
+		},
+		{
+			name:       "non-json file is not an item",
+			entry:      "resources/deployments.apps/namespaces/ns-1/web.yaml",
+			expectedOK: false,
+		},
+		{
+			name:       "unknown scope dir is not an item",
+			entry:      "resources/deployments.apps/other/ns-1/web.json",
+			expectedOK: false,
+		},
+		{
+			name:       "directory entry is not an item",
+			entry:      "resources/deployments.apps/namespaces",
+			expectedOK: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, ok := ParseItemPath(test.entry)
+			assert.Equal(t, test.expectedOK, ok)
+			assert.Equal(t, test.expectedPath, path)
+		})
+	}
+}
+
+// buildArchive writes a gzipped tarball with the given entries; values are
+// JSON-encoded item content, except strings, which are written verbatim.
+func buildArchive(t *testing.T, entries map[string]interface{}, order []string) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+
+	for _, name := range order {
+		var data []byte
+		switch val := entries[name].(type) {
+		case string:
+			data = []byte(val)
+		default:
+			var err error
+			data, err = json.Marshal(val)
+			require.NoError(t, err)
+		}
+
+		require.NoError(t, tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Size:     int64(len(data)),
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+		}))
+		_, err := tw.Write(data)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+
+	return buf.Bytes()
+}
+
+func item(namespace, name string, replicas int) map[string]interface{} {
+	metadata := map[string]interface{}{"name": name}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   metadata,
+		"spec":       map[string]interface{}{"replicas": float64(replicas)},
+	}
+}
+
+func TestWalk(t *testing.T) {
+	archive := buildArchive(t, map[string]interface{}{
+		"ark-backup.json": `{"kind":"Backup"}`,
+		"resources/deployments.apps/namespaces/ns-1/web.json": item("ns-1", "web", 3),
+		"resources/deployments.apps/namespaces/ns-2/db.json":  item("ns-2", "db", 1),
+	}, []string{
+		"ark-backup.json",
+		"resources/deployments.apps/namespaces/ns-1/web.json",
+		"resources/deployments.apps/namespaces/ns-2/db.json",
+	})
+
+	var visited []ItemPath
+	err := Walk(bytes.NewReader(archive), func(path ItemPath, item *unstructured.Unstructured) error {
+		visited = append(visited, path)
+		assert.Equal(t, path.Name, item.GetName())
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, []ItemPath{
+		{GroupResource: "deployments.apps", Namespace: "ns-1", Name: "web"},
+		{GroupResource: "deployments.apps", Namespace: "ns-2", Name: "db"},
+	}, visited)
+}
+
+func TestRewrite(t *testing.T) {
+	archive := buildArchive(t, map[string]interface{}{
+		"ark-backup.json": `{"kind":"Backup"}`,
+		"resources/deployments.apps/namespaces/ns-1/web.json": item("ns-1", "web", 3),
+		"resources/deployments.apps/namespaces/ns-2/db.json":  item("ns-2", "db", 1),
+	}, []string{
+		"ark-backup.json",
+		"resources/deployments.apps/namespaces/ns-1/web.json",
+		"resources/deployments.apps/namespaces/ns-2/db.json",
+	})
+
+	var out bytes.Buffer
+	err := Rewrite(bytes.NewReader(archive), &out, func(path ItemPath, item *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		if path.Name != "web" {
+			// nil leaves the item unchanged
+			return nil, nil
+		}
+		require.NoError(t, unstructured.SetNestedField(item.Object, float64(0), "spec", "replicas"))
+		return item, nil
+	})
+	require.NoError(t, err)
+
+	// read the rewritten archive back and verify the transform was applied
+	// to web only, and the metadata file survived verbatim
+	gzr, err := gzip.NewReader(bytes.NewReader(out.Bytes()))
+	require.NoError(t, err)
+	defer gzr.Close()
+
+	contents := make(map[string]string)
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		var buf bytes.Buffer
+		_, err = buf.ReadFrom(tr)
+		require.NoError(t, err)
+		contents[header.Name] = buf.String()
+	}
+
+	assert.Equal(t, `{"kind":"Backup"}`, contents["ark-backup.json"])
+
+	var web map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(contents["resources/deployments.apps/namespaces/ns-1/web.json"]), &web))
+	replicas, _, err := unstructured.NestedFloat64(web, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), replicas)
+
+	var db map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(contents["resources/deployments.apps/namespaces/ns-2/db.json"]), &db))
+	replicas, _, err = unstructured.NestedFloat64(db, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(1), replicas)
+}
+
+func TestRewriteTransformError(t *testing.T) {
+	archive := buildArchive(t, map[string]interface{}{
+		"resources/deployments.apps/namespaces/ns-1/web.json": item("ns-1", "web", 3),
+	}, []string{
+		"resources/deployments.apps/namespaces/ns-1/web.json",
+	})
+
+	var out bytes.Buffer
+	err := Rewrite(bytes.NewReader(archive), &out, func(path ItemPath, item *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+		return nil, assert.AnError
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/rest"
+)
+
+// Environment variables tuning the client-go rate limiter for the plugin's
+// live API calls, so large backups don't hammer the API server (or,
+// conversely, aren't needlessly throttled by client-go's low defaults).
+const (
+	ClientQPSEnvVar   = "ARK_SCALE_CLIENT_QPS"
+	ClientBurstEnvVar = "ARK_SCALE_CLIENT_BURST"
+)
+
+// ApplyClientConfigOverrides applies the configured QPS and burst settings
+// to the client config, leaving client-go's defaults in place when unset.
+func ApplyClientConfigOverrides(clientConfig *rest.Config) error {
+	if val := os.Getenv(ClientQPSEnvVar); val != "" {
+		qps, err := strconv.ParseFloat(val, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value %q for %s", val, ClientQPSEnvVar)
+		}
+		clientConfig.QPS = float32(qps)
+	}
+
+	if val := os.Getenv(ClientBurstEnvVar); val != "" {
+		burst, err := strconv.Atoi(val)
+		if err != nil {
+			return errors.Wrapf(err, "invalid value %q for %s", val, ClientBurstEnvVar)
+		}
+		clientConfig.Burst = burst
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ClusterNameEnvVar is an operator-chosen name identifying the
+	// cluster the plugin runs in.
+	ClusterNameEnvVar = "ARK_SCALE_CLUSTER_NAME"
+
+	// SourceClusterNameAnnotation and SourceClusterUIDAnnotation are
+	// stamped on mutated items at backup time, so restores into a
+	// different cluster can adapt behavior.
+	SourceClusterNameAnnotation = "scale-deployment.ark.heptio.com/source-cluster-name"
+	SourceClusterUIDAnnotation  = "scale-deployment.ark.heptio.com/source-cluster-uid"
+)
+
+// ClusterUID returns the UID of the kube-system namespace, the
+// conventional stable identifier for a cluster.
+func ClusterUID(kubeClient kubernetes.Interface) (string, error) {
+	ns, err := kubeClient.CoreV1().Namespaces().Get("kube-system", metav1.GetOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "error getting kube-system namespace")
+	}
+	return string(ns.UID), nil
+}
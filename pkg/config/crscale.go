@@ -0,0 +1,112 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CRScaleRulesEnvVar maps operator CR kinds to the field holding their
+// instance count, as comma-separated
+// "Kind.group=field.path[:zero[:restore]]" entries, e.g.
+// "postgresql.acid.zalan.do=spec.numberOfInstances". zero is the value
+// the field is scaled to (default 0); restore is what happens after a
+// restore — "annotation" (default) records the original value for the
+// restore side to put back, "none" leaves the workload at the zero value.
+// Unset, the Zalando postgres-operator rule applies. The scale-cr action
+// uses these to quiesce databases through their operator instead of
+// behind its back, and to support new CRDs without code changes.
+const CRScaleRulesEnvVar = "ARK_SCALE_CR_SCALE_RULES"
+
+// Restore strategies for a CR scale rule.
+const (
+	// CRRestoreStrategyAnnotation records the original value in an
+	// annotation so the restore side can scale the workload back up.
+	CRRestoreStrategyAnnotation = "annotation"
+	// CRRestoreStrategyNone records nothing; the workload stays at the
+	// zero value after a restore until its owner scales it.
+	CRRestoreStrategyNone = "none"
+)
+
+// CRScaleRule maps one operator CR GroupKind to the field holding its
+// instance count, the value to scale it to, and the restore strategy.
+type CRScaleRule struct {
+	GroupKind       string
+	FieldPath       []string
+	ZeroValue       int64
+	RestoreStrategy string
+}
+
+// LoadCRScaleRules returns the configured CR scale rules indexed by
+// GroupKind.
+func LoadCRScaleRules() (map[string]CRScaleRule, error) {
+	val := os.Getenv(CRScaleRulesEnvVar)
+	if val == "" {
+		val = "postgresql.acid.zalan.do=spec.numberOfInstances"
+	}
+
+	rules := make(map[string]CRScaleRule)
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, errors.Errorf("invalid %s entry %q: expected Kind.group=field.path[:zero[:restore]]", CRScaleRulesEnvVar, entry)
+		}
+
+		rule := CRScaleRule{
+			GroupKind:       parts[0],
+			RestoreStrategy: CRRestoreStrategyAnnotation,
+		}
+
+		spec := strings.Split(parts[1], ":")
+		rule.FieldPath = strings.Split(spec[0], ".")
+
+		if len(spec) > 1 {
+			zero, err := strconv.ParseInt(spec[1], 10, 64)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s entry %q: bad zero value", CRScaleRulesEnvVar, entry)
+			}
+			rule.ZeroValue = zero
+		}
+
+		if len(spec) > 2 {
+			switch spec[2] {
+			case CRRestoreStrategyAnnotation, CRRestoreStrategyNone:
+				rule.RestoreStrategy = spec[2]
+			default:
+				return nil, errors.Errorf("invalid %s entry %q: restore strategy must be %s or %s",
+					CRScaleRulesEnvVar, entry, CRRestoreStrategyAnnotation, CRRestoreStrategyNone)
+			}
+		}
+
+		if len(spec) > 3 {
+			return nil, errors.Errorf("invalid %s entry %q: expected Kind.group=field.path[:zero[:restore]]", CRScaleRulesEnvVar, entry)
+		}
+
+		rules[parts[0]] = rule
+	}
+
+	return rules, nil
+}
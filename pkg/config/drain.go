@@ -0,0 +1,55 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DrainWaitEnvVar configures how long to wait between scaling a workload
+// down and declaring it quiesced, so in-flight requests finish before PV
+// snapshots are taken. It accepts a duration (e.g. "30s") or the special
+// value "grace-period" to wait for the workload's own
+// terminationGracePeriodSeconds.
+const DrainWaitEnvVar = "ARK_SCALE_DRAIN_WAIT"
+
+// DrainWaitGracePeriod is the DrainWaitEnvVar value that derives the wait
+// from the workload's terminationGracePeriodSeconds.
+const DrainWaitGracePeriod = "grace-period"
+
+// LoadDrainWait returns the configured drain wait. useGracePeriod is true
+// when the wait should be derived from the workload's
+// terminationGracePeriodSeconds instead of the fixed duration.
+func LoadDrainWait() (wait time.Duration, useGracePeriod bool, err error) {
+	val := os.Getenv(DrainWaitEnvVar)
+	switch val {
+	case "":
+		return 0, false, nil
+	case DrainWaitGracePeriod:
+		return 0, true, nil
+	}
+
+	wait, err = time.ParseDuration(val)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "invalid value %q for %s", val, DrainWaitEnvVar)
+	}
+
+	return wait, false, nil
+}
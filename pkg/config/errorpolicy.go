@@ -0,0 +1,87 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrorPolicyEnvVar controls what happens when processing a single item
+// fails: a comma-separated list where a bare policy sets the global
+// default and "namespace=policy" entries override it per namespace, e.g.
+// "warn,prod=fail". Defaults to fail, matching historical behavior.
+const ErrorPolicyEnvVar = "ARK_SCALE_ERROR_POLICY"
+
+// ErrorPolicy is what the action does with an item whose processing
+// failed.
+type ErrorPolicy string
+
+const (
+	// ErrorPolicyFail fails the backup item, surfacing the error in the
+	// backup's error count.
+	ErrorPolicyFail ErrorPolicy = "fail"
+	// ErrorPolicyWarn logs the error and backs the item up unmodified.
+	ErrorPolicyWarn ErrorPolicy = "warn"
+	// ErrorPolicySkip backs the item up unmodified with an annotation
+	// recording why it was skipped.
+	ErrorPolicySkip ErrorPolicy = "skip"
+)
+
+// ErrorPolicies resolves the error policy for an item's namespace.
+type ErrorPolicies struct {
+	global      ErrorPolicy
+	byNamespace map[string]ErrorPolicy
+}
+
+// LoadErrorPolicies reads the error policy configuration from the
+// environment.
+func LoadErrorPolicies() (*ErrorPolicies, error) {
+	policies := &ErrorPolicies{
+		global:      ErrorPolicyFail,
+		byNamespace: make(map[string]ErrorPolicy),
+	}
+
+	for _, entry := range splitAndTrim(os.Getenv(ErrorPolicyEnvVar)) {
+		parts := strings.SplitN(entry, "=", 2)
+
+		policy := ErrorPolicy(parts[len(parts)-1])
+		switch policy {
+		case ErrorPolicyFail, ErrorPolicyWarn, ErrorPolicySkip:
+		default:
+			return nil, errors.Errorf("invalid %s entry %q: policy must be one of %q, %q, %q", ErrorPolicyEnvVar, entry, ErrorPolicyFail, ErrorPolicyWarn, ErrorPolicySkip)
+		}
+
+		if len(parts) == 1 {
+			policies.global = policy
+		} else {
+			policies.byNamespace[strings.TrimSpace(parts[0])] = policy
+		}
+	}
+
+	return policies, nil
+}
+
+// ForNamespace returns the policy for items in the given namespace.
+func (p *ErrorPolicies) ForNamespace(namespace string) ErrorPolicy {
+	if policy, ok := p.byNamespace[namespace]; ok {
+		return policy
+	}
+	return p.global
+}
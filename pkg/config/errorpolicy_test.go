@@ -0,0 +1,98 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadErrorPolicies(t *testing.T) {
+	tests := []struct {
+		name        string
+		val         string
+		expectError bool
+
+		// namespace → expected policy, checked via ForNamespace.
+		expected map[string]ErrorPolicy
+	}{
+		{
+			name: "unset defaults to fail",
+			val:  "",
+			expected: map[string]ErrorPolicy{
+				"any": ErrorPolicyFail,
+			},
+		},
+		{
+			name: "bare policy sets the global default",
+			val:  "warn",
+			expected: map[string]ErrorPolicy{
+				"any": ErrorPolicyWarn,
+			},
+		},
+		{
+			name: "namespace entries override the default",
+			val:  "warn,prod=fail,staging=skip",
+			expected: map[string]ErrorPolicy{
+				"prod":    ErrorPolicyFail,
+				"staging": ErrorPolicySkip,
+				"other":   ErrorPolicyWarn,
+			},
+		},
+		{
+			name: "namespace override without a global default",
+			val:  "prod=skip",
+			expected: map[string]ErrorPolicy{
+				"prod":  ErrorPolicySkip,
+				"other": ErrorPolicyFail,
+			},
+		},
+		{
+			name:        "unknown policy",
+			val:         "retry",
+			expectError: true,
+		},
+		{
+			name:        "unknown policy in a namespace entry",
+			val:         "warn,prod=retry",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.val != "" {
+				os.Setenv(ErrorPolicyEnvVar, test.val)
+				defer os.Unsetenv(ErrorPolicyEnvVar)
+			}
+
+			policies, err := LoadErrorPolicies()
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			for namespace, expected := range test.expected {
+				assert.Equal(t, expected, policies.ForNamespace(namespace), "namespace %s", namespace)
+			}
+		})
+	}
+}
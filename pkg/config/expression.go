@@ -0,0 +1,522 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// FilterExpressionEnvVar is a filter expression evaluated against
+	// each item (bound as `object`); the action only processes items for
+	// which it's true. Unset means all selected items are processed.
+	FilterExpressionEnvVar = "ARK_SCALE_FILTER_EXPRESSION"
+
+	// TargetReplicasExpressionEnvVar is a filter expression evaluated
+	// against each item that yields the item's target replica count,
+	// overriding the profile's static target, e.g.
+	// `object.metadata.labels["tier"] == "web" ? 0 : 1`.
+	TargetReplicasExpressionEnvVar = "ARK_SCALE_TARGET_REPLICAS_EXPRESSION"
+)
+
+// Expression is a compiled expression in the plugin's small filter
+// language. The full grammar is: `object` field paths (dotted or
+// ["key"]-indexed), string and int literals, true/false, ==, !=, !, &&,
+// ||, the ?: conditional, and parentheses — nothing else. Absent fields
+// evaluate to null, which compares unequal to everything but null.
+// Numeric comparisons normalize int and float values before comparing.
+type Expression struct {
+	src  string
+	root exprNode
+}
+
+// CompileExpression parses src into an evaluable Expression.
+func CompileExpression(src string) (*Expression, error) {
+	tokens, err := lexExpression(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing expression %q", src)
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing expression %q", src)
+	}
+	if p.pos != len(p.tokens) {
+		return nil, errors.Errorf("error parsing expression %q: unexpected %q", src, p.tokens[p.pos].text)
+	}
+
+	return &Expression{src: src, root: root}, nil
+}
+
+// LoadFilterExpression returns the compiled item filter expression, or
+// nil if none is configured.
+func LoadFilterExpression() (*Expression, error) {
+	return compileFromEnv(FilterExpressionEnvVar)
+}
+
+// LoadTargetReplicasExpression returns the compiled target replicas
+// expression, or nil if none is configured.
+func LoadTargetReplicasExpression() (*Expression, error) {
+	return compileFromEnv(TargetReplicasExpressionEnvVar)
+}
+
+func compileFromEnv(envVar string) (*Expression, error) {
+	src := os.Getenv(envVar)
+	if src == "" {
+		return nil, nil
+	}
+
+	expr, err := CompileExpression(src)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s", envVar)
+	}
+	return expr, nil
+}
+
+// EvalBool evaluates the expression against an item's content and returns
+// its boolean result.
+func (e *Expression) EvalBool(object map[string]interface{}) (bool, error) {
+	val, err := e.root.eval(object)
+	if err != nil {
+		return false, errors.Wrapf(err, "error evaluating expression %q", e.src)
+	}
+
+	b, ok := val.(bool)
+	if !ok {
+		return false, errors.Errorf("expression %q did not evaluate to a boolean", e.src)
+	}
+	return b, nil
+}
+
+// EvalInt evaluates the expression against an item's content and returns
+// its integer result.
+func (e *Expression) EvalInt(object map[string]interface{}) (int64, error) {
+	val, err := e.root.eval(object)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error evaluating expression %q", e.src)
+	}
+
+	switch v := val.(type) {
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	default:
+		return 0, errors.Errorf("expression %q did not evaluate to an integer", e.src)
+	}
+}
+
+type exprNode interface {
+	eval(object map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	val interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.val, nil
+}
+
+// pathNode resolves a field path rooted at `object`; any missing segment
+// yields null.
+type pathNode struct {
+	segments []string
+}
+
+func (n pathNode) eval(object map[string]interface{}) (interface{}, error) {
+	var current interface{} = object
+	for _, segment := range n.segments {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		current = m[segment]
+	}
+	return current, nil
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n notNode) eval(object map[string]interface{}) (interface{}, error) {
+	val, err := n.operand.eval(object)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := val.(bool)
+	if !ok {
+		return nil, errors.New("operand of ! is not a boolean")
+	}
+	return !b, nil
+}
+
+type binaryNode struct {
+	op       string
+	lhs, rhs exprNode
+}
+
+func (n binaryNode) eval(object map[string]interface{}) (interface{}, error) {
+	lhs, err := n.lhs.eval(object)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "&&", "||":
+		lb, ok := lhs.(bool)
+		if !ok {
+			return nil, errors.Errorf("operand of %s is not a boolean", n.op)
+		}
+		// && and || short-circuit.
+		if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+			return lb, nil
+		}
+		rhs, err := n.rhs.eval(object)
+		if err != nil {
+			return nil, err
+		}
+		rb, ok := rhs.(bool)
+		if !ok {
+			return nil, errors.Errorf("operand of %s is not a boolean", n.op)
+		}
+		return rb, nil
+	case "==", "!=":
+		rhs, err := n.rhs.eval(object)
+		if err != nil {
+			return nil, err
+		}
+		eq := valuesEqual(lhs, rhs)
+		if n.op == "!=" {
+			eq = !eq
+		}
+		return eq, nil
+	default:
+		return nil, errors.Errorf("unsupported operator %q", n.op)
+	}
+}
+
+type conditionalNode struct {
+	cond, then, els exprNode
+}
+
+func (n conditionalNode) eval(object map[string]interface{}) (interface{}, error) {
+	cond, err := n.cond.eval(object)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cond.(bool)
+	if !ok {
+		return nil, errors.New("condition of ?: is not a boolean")
+	}
+	if b {
+		return n.then.eval(object)
+	}
+	return n.els.eval(object)
+}
+
+// valuesEqual compares two values, normalizing the numeric types found in
+// unstructured content so 1 == 1.0.
+func valuesEqual(lhs, rhs interface{}) bool {
+	if ln, lok := asFloat(lhs); lok {
+		rn, rok := asFloat(rhs)
+		return rok && ln == rn
+	}
+	return lhs == rhs
+}
+
+func asFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+type exprToken struct {
+	text string
+	// isString marks a token that was a quoted string literal, so "true"
+	// the string isn't confused with true the keyword.
+	isString bool
+}
+
+func lexExpression(src string) ([]exprToken, error) {
+	var tokens []exprToken
+
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var sb strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, errors.New("unterminated string literal")
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					sb.WriteRune(runes[i+1])
+					i += 2
+					continue
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, exprToken{text: sb.String(), isString: true})
+		case unicode.IsDigit(r):
+			start := i
+			for i < len(runes) && unicode.IsDigit(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, exprToken{text: string(runes[start:i])})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, exprToken{text: string(runes[start:i])})
+		case strings.ContainsRune("=!&|", r):
+			if i+1 < len(runes) {
+				switch op := string(runes[i : i+2]); op {
+				case "==", "!=", "&&", "||":
+					tokens = append(tokens, exprToken{text: op})
+					i += 2
+					continue
+				}
+			}
+			if r != '!' {
+				return nil, errors.Errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, exprToken{text: "!"})
+			i++
+		case strings.ContainsRune("?:.[]()", r):
+			tokens = append(tokens, exprToken{text: string(r)})
+			i++
+		default:
+			return nil, errors.Errorf("unexpected character %q", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) peek() (exprToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return exprToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *exprParser) accept(text string) bool {
+	if tok, ok := p.peek(); ok && !tok.isString && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		return errors.Errorf("expected %q", text)
+	}
+	return nil
+}
+
+// parseExpr := parseOr ("?" parseExpr ":" parseExpr)?
+func (p *exprParser) parseExpr() (exprNode, error) {
+	cond, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	then, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	els, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	return conditionalNode{cond: cond, then: then, els: els}, nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	lhs, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryNode{op: "||", lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	lhs, err := p.parseEquality()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		rhs, err := p.parseEquality()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryNode{op: "&&", lhs: lhs, rhs: rhs}
+	}
+	return lhs, nil
+}
+
+func (p *exprParser) parseEquality() (exprNode, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var op string
+		switch {
+		case p.accept("=="):
+			op = "=="
+		case p.accept("!="):
+			op = "!="
+		default:
+			return lhs, nil
+		}
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		lhs = binaryNode{op: op, lhs: lhs, rhs: rhs}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.accept("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	if p.accept("(") {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	p.pos++
+
+	if tok.isString {
+		return literalNode{val: tok.text}, nil
+	}
+
+	if unicode.IsDigit(rune(tok.text[0])) {
+		n, err := strconv.ParseInt(tok.text, 10, 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid integer literal %q", tok.text)
+		}
+		return literalNode{val: n}, nil
+	}
+
+	switch tok.text {
+	case "true":
+		return literalNode{val: true}, nil
+	case "false":
+		return literalNode{val: false}, nil
+	case "null":
+		return literalNode{val: nil}, nil
+	}
+
+	if tok.text != "object" {
+		return nil, errors.Errorf("unknown identifier %q (expressions are evaluated against `object`)", tok.text)
+	}
+
+	segments := []string{}
+	for {
+		if p.accept(".") {
+			next, ok := p.peek()
+			if !ok || next.isString {
+				return nil, errors.New("expected field name after '.'")
+			}
+			p.pos++
+			segments = append(segments, next.text)
+			continue
+		}
+		if p.accept("[") {
+			key, ok := p.peek()
+			if !ok || !key.isString {
+				return nil, errors.New("expected string key inside [ ]")
+			}
+			p.pos++
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			segments = append(segments, key.text)
+			continue
+		}
+		break
+	}
+
+	return pathNode{segments: segments}, nil
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testObject() map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"name":      "web",
+			"namespace": "ns-1",
+			"labels": map[string]interface{}{
+				"tier":                   "web",
+				"app.kubernetes.io/name": "web",
+			},
+		},
+		"spec": map[string]interface{}{
+			// unstructured content decoded from JSON carries float64s
+			"replicas": float64(3),
+		},
+	}
+}
+
+func TestExpressionEvalBool(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected bool
+	}{
+		{
+			name:     "dotted field path equality",
+			src:      `object.metadata.namespace == "ns-1"`,
+			expected: true,
+		},
+		{
+			name:     "bracket-indexed field path",
+			src:      `object.metadata.labels["tier"] == "web"`,
+			expected: true,
+		},
+		{
+			name:     "bracket index with non-identifier key",
+			src:      `object.metadata.labels["app.kubernetes.io/name"] == "web"`,
+			expected: true,
+		},
+		{
+			name:     "inequality",
+			src:      `object.metadata.name != "db"`,
+			expected: true,
+		},
+		{
+			name:     "absent field is null",
+			src:      `object.metadata.annotations == null`,
+			expected: true,
+		},
+		{
+			name:     "absent field compares unequal to a string",
+			src:      `object.metadata.annotations == "x"`,
+			expected: false,
+		},
+		{
+			name:     "int literal matches float64 content",
+			src:      `object.spec.replicas == 3`,
+			expected: true,
+		},
+		{
+			name:     "negation",
+			src:      `!(object.metadata.name == "db")`,
+			expected: true,
+		},
+		{
+			name:     "conjunction",
+			src:      `object.metadata.namespace == "ns-1" && object.metadata.name == "web"`,
+			expected: true,
+		},
+		{
+			name:     "disjunction",
+			src:      `object.metadata.name == "db" || object.metadata.name == "web"`,
+			expected: true,
+		},
+		{
+			name:     "conditional",
+			src:      `object.metadata.labels["tier"] == "web" ? true : false`,
+			expected: true,
+		},
+		{
+			name:     "boolean literals",
+			src:      `true && !false`,
+			expected: true,
+		},
+		{
+			// the lhs is not a boolean, but && must not evaluate the rhs
+			// when the lhs is false
+			name:     "short-circuited rhs is not evaluated",
+			src:      `object.metadata.name == "db" && object.spec.replicas`,
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := CompileExpression(test.src)
+			require.NoError(t, err)
+
+			result, err := expr.EvalBool(testObject())
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestExpressionEvalInt(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		expected int64
+	}{
+		{
+			name:     "integer literal",
+			src:      `2`,
+			expected: 2,
+		},
+		{
+			name:     "field path yielding float64 content",
+			src:      `object.spec.replicas`,
+			expected: 3,
+		},
+		{
+			name:     "conditional selecting a target",
+			src:      `object.metadata.labels["tier"] == "web" ? 0 : 1`,
+			expected: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := CompileExpression(test.src)
+			require.NoError(t, err)
+
+			result, err := expr.EvalInt(testObject())
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, result)
+		})
+	}
+}
+
+func TestCompileExpressionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "unknown identifier",
+			src:  `item.metadata.name == "web"`,
+		},
+		{
+			name: "trailing tokens",
+			src:  `object.metadata.name == "web" extra`,
+		},
+		{
+			name: "unterminated string literal",
+			src:  `object.metadata.name == "web`,
+		},
+		{
+			name: "non-string key inside brackets",
+			src:  `object.metadata.labels[tier] == "web"`,
+		},
+		{
+			name: "missing conditional else",
+			src:  `true ? 1`,
+		},
+		{
+			name: "unexpected character",
+			src:  `object.spec.replicas > 1`,
+		},
+		{
+			name: "empty expression",
+			src:  ` `,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := CompileExpression(test.src)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestExpressionEvalErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		eval func(*Expression) error
+	}{
+		{
+			name: "EvalBool on a non-boolean result",
+			src:  `object.metadata.name`,
+			eval: func(e *Expression) error {
+				_, err := e.EvalBool(testObject())
+				return err
+			},
+		},
+		{
+			name: "EvalInt on a non-integer result",
+			src:  `object.metadata.name`,
+			eval: func(e *Expression) error {
+				_, err := e.EvalInt(testObject())
+				return err
+			},
+		},
+		{
+			name: "non-boolean operand of !",
+			src:  `!object.metadata.name`,
+			eval: func(e *Expression) error {
+				_, err := e.EvalBool(testObject())
+				return err
+			},
+		},
+		{
+			name: "non-boolean condition of ?:",
+			src:  `object.metadata.name ? 1 : 2`,
+			eval: func(e *Expression) error {
+				_, err := e.EvalInt(testObject())
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expr, err := CompileExpression(test.src)
+			require.NoError(t, err)
+			assert.Error(t, test.eval(expr))
+		})
+	}
+}
+
+func TestCompileFromEnv(t *testing.T) {
+	require.NoError(t, os.Unsetenv(FilterExpressionEnvVar))
+
+	expr, err := LoadFilterExpression()
+	require.NoError(t, err)
+	assert.Nil(t, expr)
+
+	require.NoError(t, os.Setenv(FilterExpressionEnvVar, `object.metadata.name == "web"`))
+	defer os.Unsetenv(FilterExpressionEnvVar)
+
+	expr, err = LoadFilterExpression()
+	require.NoError(t, err)
+	require.NotNil(t, expr)
+
+	result, err := expr.EvalBool(testObject())
+	require.NoError(t, err)
+	assert.True(t, result)
+
+	require.NoError(t, os.Setenv(FilterExpressionEnvVar, `object.metadata.name ==`))
+	_, err = LoadFilterExpression()
+	assert.Error(t, err)
+}
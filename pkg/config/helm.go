@@ -0,0 +1,88 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+)
+
+// Environment variables controlling how Helm-managed workloads are treated.
+// Since `helm upgrade` after a restore resets replicas anyway, zeroing
+// Helm-managed Deployments often only causes confusion.
+const (
+	// SkipHelmReleasesEnvVar skips all Helm-managed workloads when set to
+	// "true", except releases named in the allow list.
+	SkipHelmReleasesEnvVar = "ARK_SCALE_SKIP_HELM_RELEASES"
+
+	// HelmReleaseAllowListEnvVar is a comma-separated list of release names
+	// that are processed even when ARK_SCALE_SKIP_HELM_RELEASES is set.
+	HelmReleaseAllowListEnvVar = "ARK_SCALE_HELM_RELEASE_ALLOWLIST"
+
+	// HelmReleaseDenyListEnvVar is a comma-separated list of release names
+	// that are always skipped.
+	HelmReleaseDenyListEnvVar = "ARK_SCALE_HELM_RELEASE_DENYLIST"
+)
+
+const (
+	helmHeritageLabel = "heritage"
+	helmReleaseLabel  = "release"
+)
+
+// HelmPolicy decides whether Helm-managed workloads are skipped by the
+// scale actions.
+type HelmPolicy struct {
+	skipReleases bool
+	allow        map[string]bool
+	deny         map[string]bool
+}
+
+// LoadHelmPolicy returns the Helm handling policy from the environment.
+func LoadHelmPolicy() HelmPolicy {
+	return HelmPolicy{
+		skipReleases: os.Getenv(SkipHelmReleasesEnvVar) == "true",
+		allow:        toSet(splitAndTrim(os.Getenv(HelmReleaseAllowListEnvVar))),
+		deny:         toSet(splitAndTrim(os.Getenv(HelmReleaseDenyListEnvVar))),
+	}
+}
+
+// ShouldSkip returns whether a workload with the given labels is
+// Helm-managed and should be skipped, along with the release name.
+func (p HelmPolicy) ShouldSkip(labels map[string]string) (bool, string) {
+	heritage := labels[helmHeritageLabel]
+	if heritage != "Tiller" && heritage != "Helm" {
+		return false, ""
+	}
+
+	release := labels[helmReleaseLabel]
+
+	if p.deny[release] {
+		return true, release
+	}
+	if p.skipReleases && !p.allow[release] {
+		return true, release
+	}
+
+	return false, release
+}
+
+func toSet(items []string) map[string]bool {
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+// PreHooksEnvVar holds a JSON map of resource (e.g. "deployments.apps") to
+// a PreHook run in each of the workload's pods before a live scale-down.
+const PreHooksEnvVar = "ARK_SCALE_PRE_HOOKS"
+
+// PreHook describes a command run in a workload's pods before the workload
+// is live-quiesced, e.g. to flush a database or stop accepting writes. It
+// mirrors Ark's backup hook semantics but is driven by the plugin's own
+// per-kind config.
+type PreHook struct {
+	// Container is the container to exec in; defaults to the pod's first
+	// container.
+	Container string `json:"container,omitempty"`
+
+	// Command is the command to run.
+	Command []string `json:"command"`
+
+	// OnError is "Fail" (default) or "Continue".
+	OnError v1.HookErrorMode `json:"onError,omitempty"`
+
+	// TimeoutSeconds bounds how long the command may run.
+	TimeoutSeconds int64 `json:"timeoutSeconds,omitempty"`
+}
+
+// ExecHook converts the PreHook into Ark's ExecHook type.
+func (h PreHook) ExecHook() *v1.ExecHook {
+	return &v1.ExecHook{
+		Container: h.Container,
+		Command:   h.Command,
+		OnError:   h.OnError,
+		Timeout:   metav1.Duration{Duration: time.Duration(h.TimeoutSeconds) * time.Second},
+	}
+}
+
+// LoadPreHooks returns the per-kind pre-freeze hooks defined in
+// ARK_SCALE_PRE_HOOKS, keyed by resource.
+func LoadPreHooks() (map[string]PreHook, error) {
+	val := os.Getenv(PreHooksEnvVar)
+	if val == "" {
+		return nil, nil
+	}
+
+	var hooks map[string]PreHook
+	if err := json.Unmarshal([]byte(val), &hooks); err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", PreHooksEnvVar)
+	}
+
+	for resource, hook := range hooks {
+		if len(hook.Command) == 0 {
+			return nil, errors.Errorf("pre-hook for %q has no command", resource)
+		}
+	}
+
+	return hooks, nil
+}
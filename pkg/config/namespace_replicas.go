@@ -0,0 +1,52 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// NamespaceReplicasEnvVar holds a JSON map of namespace to the replica
+// count workloads restored into that namespace default to when no per-item
+// override exists (e.g. staging namespaces restore at 1 replica regardless
+// of the original count).
+const NamespaceReplicasEnvVar = "ARK_SCALE_NAMESPACE_REPLICAS"
+
+// LoadNamespaceReplicaDefaults returns the per-namespace default restore
+// replica counts.
+func LoadNamespaceReplicaDefaults() (map[string]int64, error) {
+	val := os.Getenv(NamespaceReplicasEnvVar)
+	if val == "" {
+		return nil, nil
+	}
+
+	var defaults map[string]int64
+	if err := json.Unmarshal([]byte(val), &defaults); err != nil {
+		return nil, errors.Wrapf(err, "error parsing %s", NamespaceReplicasEnvVar)
+	}
+
+	for namespace, replicas := range defaults {
+		if replicas < 0 {
+			return nil, errors.Errorf("namespace %q has negative default replicas %d", namespace, replicas)
+		}
+	}
+
+	return defaults, nil
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// PersistenceModeEnvVar selects how original replica counts are persisted:
+// as annotations on each item (the default), or collected into a single
+// per-backup ConfigMap per namespace, which survives tools that strip
+// unknown annotations and gives operators one document to review.
+const PersistenceModeEnvVar = "ARK_SCALE_PERSISTENCE_MODE"
+
+// PersistenceMode is a replica-count persistence strategy.
+type PersistenceMode string
+
+const (
+	// PersistenceModeAnnotations stores original replica counts in an
+	// annotation on each backed-up item.
+	PersistenceModeAnnotations PersistenceMode = "annotations"
+
+	// PersistenceModeConfigMap stores all original replica counts for a
+	// backup in a ConfigMap per namespace, included in the backup as an
+	// additional item.
+	PersistenceModeConfigMap PersistenceMode = "configmap"
+)
+
+// LoadPersistenceMode returns the configured persistence mode, defaulting
+// to annotations.
+func LoadPersistenceMode() (PersistenceMode, error) {
+	switch val := os.Getenv(PersistenceModeEnvVar); val {
+	case "", string(PersistenceModeAnnotations):
+		return PersistenceModeAnnotations, nil
+	case string(PersistenceModeConfigMap):
+		return PersistenceModeConfigMap, nil
+	default:
+		return "", errors.Errorf("invalid value %q for %s; must be %q or %q",
+			val, PersistenceModeEnvVar, PersistenceModeAnnotations, PersistenceModeConfigMap)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PolicyConfigMapEnvVar names a ConfigMap, as "namespace/name", whose data
+// is applied over the plugin's environment configuration. The plugin
+// watches it and applies changes without a restart, so policy updates take
+// effect for the next backup instead of requiring an Ark server bounce.
+const PolicyConfigMapEnvVar = "ARK_SCALE_POLICY_CONFIGMAP"
+
+const policyPollInterval = 30 * time.Second
+
+// PolicyWatcher polls the policy ConfigMap, applies its data as
+// environment variables, and notifies subscribers when it changes.
+type PolicyWatcher struct {
+	log        logrus.FieldLogger
+	kubeClient kubernetes.Interface
+	namespace  string
+	name       string
+
+	// lastResourceVersion dedupes polls; appliedKeys tracks which
+	// variables came from the ConfigMap so removed keys get unset.
+	lastResourceVersion string
+	appliedKeys         map[string]bool
+
+	onChange []func()
+}
+
+// NewPolicyWatcherFromEnv returns a PolicyWatcher for the configured
+// ConfigMap, or nil if none is configured.
+func NewPolicyWatcherFromEnv(log logrus.FieldLogger, kubeClient kubernetes.Interface) (*PolicyWatcher, error) {
+	val := os.Getenv(PolicyConfigMapEnvVar)
+	if val == "" {
+		return nil, nil
+	}
+
+	parts := strings.SplitN(val, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.Errorf("invalid %s %q: expected namespace/name", PolicyConfigMapEnvVar, val)
+	}
+
+	return &PolicyWatcher{
+		log:         log,
+		kubeClient:  kubeClient,
+		namespace:   parts[0],
+		name:        parts[1],
+		appliedKeys: make(map[string]bool),
+	}, nil
+}
+
+// OnChange registers fn to run after the ConfigMap's data has been applied
+// to the environment. Register before calling Run.
+func (w *PolicyWatcher) OnChange(fn func()) {
+	w.onChange = append(w.onChange, fn)
+}
+
+// Run polls the ConfigMap until stopCh closes, applying changes as they
+// appear. A missing ConfigMap is not an error; the environment stands
+// until it shows up.
+func (w *PolicyWatcher) Run(stopCh <-chan struct{}) {
+	wait.Until(w.poll, policyPollInterval, stopCh)
+}
+
+func (w *PolicyWatcher) poll() {
+	cm, err := w.kubeClient.CoreV1().ConfigMaps(w.namespace).Get(w.name, metav1.GetOptions{})
+	if err != nil {
+		w.log.WithError(err).Warnf("Unable to get policy configmap %s/%s", w.namespace, w.name)
+		return
+	}
+
+	if cm.ResourceVersion == w.lastResourceVersion {
+		return
+	}
+	first := w.lastResourceVersion == ""
+	w.lastResourceVersion = cm.ResourceVersion
+
+	applied := make(map[string]bool, len(cm.Data))
+	for key, val := range cm.Data {
+		os.Setenv(key, val)
+		applied[key] = true
+	}
+	for key := range w.appliedKeys {
+		if !applied[key] {
+			os.Unsetenv(key)
+		}
+	}
+	w.appliedKeys = applied
+
+	// The first poll just seeds the environment; only real changes are
+	// worth announcing and re-deriving config for.
+	if first && len(cm.Data) == 0 {
+		return
+	}
+
+	w.log.Infof("Applied policy configmap %s/%s (resourceVersion %s)", w.namespace, w.name, cm.ResourceVersion)
+	for _, fn := range w.onChange {
+		fn()
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+const (
+	// ProfilesEnvVar holds a JSON map of profile name to Profile,
+	// merged over the built-in profiles.
+	ProfilesEnvVar = "ARK_SCALE_PROFILES"
+
+	// DefaultProfileEnvVar names the profile used for backups that don't
+	// carry the profile label.
+	DefaultProfileEnvVar = "ARK_SCALE_DEFAULT_PROFILE"
+
+	// ProfileLabel is the Backup label that selects the quiesce profile
+	// for that backup.
+	ProfileLabel = "scale-deployment.ark.heptio.com/profile"
+
+	// Built-in profile names.
+	ProfileFullQuiesce = "full-quiesce"
+	ProfileSoftQuiesce = "soft-quiesce"
+	ProfileNone        = "none"
+)
+
+// Profile bundles the quiesce behavior for a backup: the replica count
+// workloads are scaled to, the kinds the actions handle, and whether the
+// live workloads are scaled down and waited on, so a backup selects one
+// named behavior instead of a dozen independent knobs.
+type Profile struct {
+	// TargetReplicas is the replica count workloads are scaled to in the
+	// backup (and live, when LiveQuiesce is set).
+	TargetReplicas int64 `json:"targetReplicas"`
+
+	// Kinds lists the resources (e.g. "deployments.apps") the actions
+	// handle under this profile. A nil/empty slice means all kinds the
+	// actions know about.
+	Kinds []string `json:"kinds,omitempty"`
+
+	// LiveQuiesce scales the live workloads down, not just the backed-up
+	// copies. It has no effect unless the plugin was started with live
+	// quiesce enabled.
+	LiveQuiesce bool `json:"liveQuiesce"`
+
+	// WaitForTermination waits for a live-quiesced workload's pods to
+	// terminate before the item is declared quiesced.
+	WaitForTermination bool `json:"waitForTermination"`
+
+	// Skip disables the actions entirely for this backup.
+	Skip bool `json:"skip"`
+}
+
+// HandlesKind returns whether the profile covers the given resource.
+func (p Profile) HandlesKind(resource string) bool {
+	if p.Skip {
+		return false
+	}
+	if len(p.Kinds) == 0 {
+		return true
+	}
+	for _, kind := range p.Kinds {
+		if kind == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultProfiles are the built-in profiles; ARK_SCALE_PROFILES entries
+// with the same name override them.
+func defaultProfiles() map[string]Profile {
+	return map[string]Profile{
+		ProfileFullQuiesce: {
+			TargetReplicas:     0,
+			LiveQuiesce:        true,
+			WaitForTermination: true,
+		},
+		ProfileSoftQuiesce: {
+			TargetReplicas: 0,
+		},
+		ProfileNone: {
+			Skip: true,
+		},
+	}
+}
+
+// LoadProfiles returns the built-in profiles merged with any defined in
+// ARK_SCALE_PROFILES.
+func LoadProfiles() (map[string]Profile, error) {
+	profiles := defaultProfiles()
+
+	if val := os.Getenv(ProfilesEnvVar); val != "" {
+		var configured map[string]Profile
+		if err := json.Unmarshal([]byte(val), &configured); err != nil {
+			return nil, errors.Wrapf(err, "error parsing %s", ProfilesEnvVar)
+		}
+		for name, profile := range configured {
+			profiles[name] = profile
+		}
+	}
+
+	return profiles, nil
+}
+
+// ProfileForBackup returns the profile selected by the backup's profile
+// label, or the default profile if the label is absent. The default
+// profile is soft-quiesce unless overridden via ARK_SCALE_DEFAULT_PROFILE.
+func ProfileForBackup(backup *v1.Backup) (Profile, error) {
+	profiles, err := LoadProfiles()
+	if err != nil {
+		return Profile{}, err
+	}
+
+	name := backup.Labels[ProfileLabel]
+	if name == "" {
+		name = os.Getenv(DefaultProfileEnvVar)
+	}
+	if name == "" {
+		name = ProfileSoftQuiesce
+	}
+
+	profile, found := profiles[name]
+	if !found {
+		return Profile{}, errors.Errorf("backup %s selects unknown quiesce profile %q", backup.Name, name)
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/restore"
+)
+
+// Environment variables that override the ResourceSelector an action
+// returns from AppliesTo, so the same binary can be deployed with
+// different scopes across clusters. List-valued variables are
+// comma-separated.
+const (
+	IncludedResourcesEnvVar  = "ARK_SCALE_INCLUDED_RESOURCES"
+	IncludedNamespacesEnvVar = "ARK_SCALE_INCLUDED_NAMESPACES"
+	ExcludedNamespacesEnvVar = "ARK_SCALE_EXCLUDED_NAMESPACES"
+	LabelSelectorEnvVar      = "ARK_SCALE_LABEL_SELECTOR"
+)
+
+// BackupSelectorFromEnv returns a backup.ResourceSelector built from the
+// environment. defaultResources is used when ARK_SCALE_INCLUDED_RESOURCES
+// is not set.
+func BackupSelectorFromEnv(defaultResources ...string) (backup.ResourceSelector, error) {
+	resources, namespaces, excludedNamespaces, labelSelector, err := selectorFromEnv(defaultResources)
+	if err != nil {
+		return backup.ResourceSelector{}, err
+	}
+
+	return backup.ResourceSelector{
+		IncludedResources:  resources,
+		IncludedNamespaces: namespaces,
+		ExcludedNamespaces: excludedNamespaces,
+		LabelSelector:      labelSelector,
+	}, nil
+}
+
+// RestoreSelectorFromEnv returns a restore.ResourceSelector built from the
+// environment. defaultResources is used when ARK_SCALE_INCLUDED_RESOURCES
+// is not set.
+func RestoreSelectorFromEnv(defaultResources ...string) (restore.ResourceSelector, error) {
+	resources, namespaces, excludedNamespaces, labelSelector, err := selectorFromEnv(defaultResources)
+	if err != nil {
+		return restore.ResourceSelector{}, err
+	}
+
+	return restore.ResourceSelector{
+		IncludedResources:  resources,
+		IncludedNamespaces: namespaces,
+		ExcludedNamespaces: excludedNamespaces,
+		LabelSelector:      labelSelector,
+	}, nil
+}
+
+func selectorFromEnv(defaultResources []string) (resources, namespaces, excludedNamespaces []string, labelSelector string, err error) {
+	resources = defaultResources
+	if val := os.Getenv(IncludedResourcesEnvVar); val != "" {
+		resources = splitAndTrim(val)
+	}
+
+	namespaces = splitAndTrim(os.Getenv(IncludedNamespacesEnvVar))
+	excludedNamespaces = splitAndTrim(os.Getenv(ExcludedNamespacesEnvVar))
+
+	labelSelector = os.Getenv(LabelSelectorEnvVar)
+	if labelSelector != "" {
+		if _, err := labels.Parse(labelSelector); err != nil {
+			return nil, nil, nil, "", errors.Wrapf(err, "invalid value %q for %s", labelSelector, LabelSelectorEnvVar)
+		}
+	}
+
+	return resources, namespaces, excludedNamespaces, labelSelector, nil
+}
+
+// splitAndTrim splits a comma-separated value into its non-empty,
+// whitespace-trimmed elements. An empty input yields a nil slice.
+func splitAndTrim(val string) []string {
+	var out []string
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/heptio/ark/pkg/backup"
+)
+
+func TestBackupSelectorFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected backup.ResourceSelector
+	}{
+		{
+			name:     "defaults apply with a clean environment",
+			expected: backup.ResourceSelector{IncludedResources: []string{"deployments.apps"}},
+		},
+		{
+			name: "resources override replaces the default",
+			env:  map[string]string{IncludedResourcesEnvVar: "statefulsets.apps"},
+			expected: backup.ResourceSelector{
+				IncludedResources: []string{"statefulsets.apps"},
+			},
+		},
+		{
+			name: "namespace lists are split and trimmed",
+			env:  map[string]string{IncludedNamespacesEnvVar: "ns-1, ns-2 ,", ExcludedNamespacesEnvVar: "kube-system"},
+			expected: backup.ResourceSelector{
+				IncludedResources:  []string{"deployments.apps"},
+				IncludedNamespaces: []string{"ns-1", "ns-2"},
+				ExcludedNamespaces: []string{"kube-system"},
+			},
+		},
+		{
+			name: "label selector is passed through",
+			env:  map[string]string{LabelSelectorEnvVar: "app=web"},
+			expected: backup.ResourceSelector{
+				IncludedResources: []string{"deployments.apps"},
+				LabelSelector:     "app=web",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, val := range test.env {
+				os.Setenv(key, val)
+				defer os.Unsetenv(key)
+			}
+
+			selector, err := BackupSelectorFromEnv("deployments.apps")
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, selector)
+		})
+	}
+}
+
+func TestSelectorFromEnvInvalidLabelSelector(t *testing.T) {
+	os.Setenv(LabelSelectorEnvVar, "app===web")
+	defer os.Unsetenv(LabelSelectorEnvVar)
+
+	_, err := BackupSelectorFromEnv("deployments.apps")
+	assert.Error(t, err)
+
+	_, err = RestoreSelectorFromEnv("deployments.apps")
+	assert.Error(t, err)
+}
+
+func TestRestoreSelectorFromEnv(t *testing.T) {
+	os.Setenv(IncludedNamespacesEnvVar, "ns-1")
+	defer os.Unsetenv(IncludedNamespacesEnvVar)
+
+	selector, err := RestoreSelectorFromEnv("deployments.apps")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"deployments.apps"}, selector.IncludedResources)
+	assert.Equal(t, []string{"ns-1"}, selector.IncludedNamespaces)
+}
+
+func TestSplitAndTrim(t *testing.T) {
+	assert.Nil(t, splitAndTrim(""))
+	assert.Nil(t, splitAndTrim(" , ,"))
+	assert.Equal(t, []string{"a", "b"}, splitAndTrim(" a ,b"))
+}
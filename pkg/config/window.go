@@ -0,0 +1,109 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// QuiesceWindowsEnvVar holds a comma-separated list of UTC time windows
+// (e.g. "22:00-06:00,12:00-13:00") during which live quiesce is permitted.
+// Backups outside every window fall back to archive-only mutation, so
+// production apps aren't taken down during business hours. An empty value
+// permits live quiesce at any time.
+const QuiesceWindowsEnvVar = "ARK_SCALE_QUIESCE_WINDOWS"
+
+// TimeWindow is a daily window expressed in minutes since midnight UTC. A
+// window whose start is after its end spans midnight.
+type TimeWindow struct {
+	start int
+	end   int
+}
+
+// Contains returns whether t falls within the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	minutes := t.UTC().Hour()*60 + t.UTC().Minute()
+	if w.start <= w.end {
+		return minutes >= w.start && minutes < w.end
+	}
+	return minutes >= w.start || minutes < w.end
+}
+
+// LoadQuiesceWindows parses the configured quiesce windows. A nil result
+// means live quiesce is always permitted.
+func LoadQuiesceWindows() ([]TimeWindow, error) {
+	val := os.Getenv(QuiesceWindowsEnvVar)
+	if val == "" {
+		return nil, nil
+	}
+
+	var windows []TimeWindow
+	for _, spec := range splitAndTrim(val) {
+		parts := strings.Split(spec, "-")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid quiesce window %q; expected HH:MM-HH:MM", spec)
+		}
+
+		start, err := parseMinutes(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quiesce window %q", spec)
+		}
+		end, err := parseMinutes(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid quiesce window %q", spec)
+		}
+
+		windows = append(windows, TimeWindow{start: start, end: end})
+	}
+
+	return windows, nil
+}
+
+// LiveQuiescePermitted returns whether live quiesce is allowed at the given
+// time under the configured windows.
+func LiveQuiescePermitted(now time.Time) (bool, error) {
+	windows, err := LoadQuiesceWindows()
+	if err != nil {
+		return false, err
+	}
+	if len(windows) == 0 {
+		return true, nil
+	}
+
+	for _, window := range windows {
+		if window.Contains(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseMinutes(val string) (int, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(strings.TrimSpace(val), "%d:%d", &hours, &minutes); err != nil {
+		return 0, errors.Wrapf(err, "error parsing time %q", val)
+	}
+	if hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, errors.Errorf("time %q out of range", val)
+	}
+	return hours*60 + minutes, nil
+}
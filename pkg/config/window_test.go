@@ -0,0 +1,162 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func utc(hour, minute int) time.Time {
+	return time.Date(2018, time.July, 1, hour, minute, 0, 0, time.UTC)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   TimeWindow
+		time     time.Time
+		expected bool
+	}{
+		{
+			name:     "inside a same-day window",
+			window:   TimeWindow{start: 12 * 60, end: 13 * 60},
+			time:     utc(12, 30),
+			expected: true,
+		},
+		{
+			name:     "start is inclusive",
+			window:   TimeWindow{start: 12 * 60, end: 13 * 60},
+			time:     utc(12, 0),
+			expected: true,
+		},
+		{
+			name:     "end is exclusive",
+			window:   TimeWindow{start: 12 * 60, end: 13 * 60},
+			time:     utc(13, 0),
+			expected: false,
+		},
+		{
+			name:     "before the window",
+			window:   TimeWindow{start: 12 * 60, end: 13 * 60},
+			time:     utc(11, 59),
+			expected: false,
+		},
+		{
+			name:     "midnight-spanning window, late evening",
+			window:   TimeWindow{start: 22 * 60, end: 6 * 60},
+			time:     utc(23, 30),
+			expected: true,
+		},
+		{
+			name:     "midnight-spanning window, early morning",
+			window:   TimeWindow{start: 22 * 60, end: 6 * 60},
+			time:     utc(5, 59),
+			expected: true,
+		},
+		{
+			name:     "midnight-spanning window, daytime",
+			window:   TimeWindow{start: 22 * 60, end: 6 * 60},
+			time:     utc(12, 0),
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.window.Contains(test.time))
+		})
+	}
+}
+
+func TestLoadQuiesceWindows(t *testing.T) {
+	tests := []struct {
+		name        string
+		val         string
+		expected    []TimeWindow
+		expectError bool
+	}{
+		{
+			name:     "unset means always permitted",
+			val:      "",
+			expected: nil,
+		},
+		{
+			name:     "single window",
+			val:      "22:00-06:00",
+			expected: []TimeWindow{{start: 22 * 60, end: 6 * 60}},
+		},
+		{
+			name:     "multiple windows with whitespace",
+			val:      "22:00-06:00, 12:00-13:00",
+			expected: []TimeWindow{{start: 22 * 60, end: 6 * 60}, {start: 12 * 60, end: 13 * 60}},
+		},
+		{
+			name:        "missing end time",
+			val:         "22:00",
+			expectError: true,
+		},
+		{
+			name:        "hours out of range",
+			val:         "25:00-06:00",
+			expectError: true,
+		},
+		{
+			name:        "minutes out of range",
+			val:         "22:60-06:00",
+			expectError: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.val != "" {
+				os.Setenv(QuiesceWindowsEnvVar, test.val)
+				defer os.Unsetenv(QuiesceWindowsEnvVar)
+			}
+
+			windows, err := LoadQuiesceWindows()
+			if test.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, windows)
+		})
+	}
+}
+
+func TestLiveQuiescePermitted(t *testing.T) {
+	permitted, err := LiveQuiescePermitted(utc(12, 0))
+	require.NoError(t, err)
+	assert.True(t, permitted, "no windows configured should permit live quiesce")
+
+	os.Setenv(QuiesceWindowsEnvVar, "22:00-06:00")
+	defer os.Unsetenv(QuiesceWindowsEnvVar)
+
+	permitted, err = LiveQuiescePermitted(utc(23, 0))
+	require.NoError(t, err)
+	assert.True(t, permitted)
+
+	permitted, err = LiveQuiescePermitted(utc(12, 0))
+	require.NoError(t, err)
+	assert.False(t, permitted)
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conformance is a table-driven suite that forks or alternative
+// implementations of the scale actions can import and run from their own
+// tests, verifying that annotation formats, skip semantics, and restore
+// round-trip behavior stay compatible with this plugin.
+package conformance
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/controller"
+	"github.com/skriss/ark-scale-deployment/pkg/plugintest"
+)
+
+// deploymentFixture returns an apps/v1 Deployment item; replicas < 0 means
+// the field is absent.
+func deploymentFixture(namespace, name string, replicas int64) string {
+	spec := fmt.Sprintf(`{"replicas": %d}`, replicas)
+	if replicas < 0 {
+		spec = `{}`
+	}
+
+	return fmt.Sprintf(`{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": {"namespace": %q, "name": %q},
+		"spec": %s
+	}`, namespace, name, spec)
+}
+
+// RunBackupConformance verifies a backup-side scale action: replicas are
+// zeroed, the original count is recorded in the original-replicas
+// annotation as a base-10 string, and items without a replica count are
+// left unmodified.
+func RunBackupConformance(t *testing.T, action backup.ItemAction) {
+	tests := []struct {
+		name               string
+		fixture            string
+		expectReplicas     int64
+		expectOriginal     string
+		expectNoAnnotation bool
+	}{
+		{
+			name:           "replicas are zeroed and recorded",
+			fixture:        deploymentFixture("web", "frontend", 3),
+			expectReplicas: 0,
+			expectOriginal: "3",
+		},
+		{
+			name:           "zero replicas round-trip",
+			fixture:        deploymentFixture("web", "stopped", 0),
+			expectReplicas: 0,
+			expectOriginal: "0",
+		},
+		{
+			name:               "items without replicas are not annotated",
+			fixture:            deploymentFixture("web", "no-replicas", -1),
+			expectReplicas:     0,
+			expectNoAnnotation: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			item, err := plugintest.ItemFromJSON([]byte(test.fixture))
+			if err != nil {
+				t.Fatalf("error building fixture: %v", err)
+			}
+
+			result, err := plugintest.ExecuteBackupItemAction(action, item, plugintest.NewBackup("conformance"))
+			if err != nil {
+				t.Fatalf("error executing action: %v", err)
+			}
+			if result.Skipped {
+				t.Fatal("action's selector did not match an apps/v1 Deployment")
+			}
+
+			content := result.Item.UnstructuredContent()
+
+			annotations, _, err := unstructured.NestedStringMap(content, "metadata", "annotations")
+			if err != nil {
+				t.Fatalf("error getting annotations: %v", err)
+			}
+			original, ok := annotations[controller.OriginalReplicasAnnotation]
+
+			if test.expectNoAnnotation {
+				if ok {
+					t.Errorf("unexpected %s annotation %q", controller.OriginalReplicasAnnotation, original)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("missing %s annotation", controller.OriginalReplicasAnnotation)
+			}
+			if original != test.expectOriginal {
+				t.Errorf("%s annotation = %q, want %q", controller.OriginalReplicasAnnotation, original, test.expectOriginal)
+			}
+
+			replicas, found, err := unstructured.NestedInt64(content, "spec", "replicas")
+			if err != nil || !found {
+				t.Fatalf("missing .spec.replicas (err: %v)", err)
+			}
+			if replicas != test.expectReplicas {
+				t.Errorf(".spec.replicas = %d, want %d", replicas, test.expectReplicas)
+			}
+		})
+	}
+}
+
+// RunRestoreConformance verifies a restore-side scale action: the
+// original-replicas annotation is honored and removed, and items without
+// it are left unmodified.
+func RunRestoreConformance(t *testing.T, action restore.ItemAction) {
+	t.Run("annotation is honored and removed", func(t *testing.T) {
+		fixture := fmt.Sprintf(`{
+			"apiVersion": "apps/v1",
+			"kind": "Deployment",
+			"metadata": {
+				"namespace": "web",
+				"name": "frontend",
+				"annotations": {%q: "5"}
+			},
+			"spec": {"replicas": 0}
+		}`, controller.OriginalReplicasAnnotation)
+
+		item, err := plugintest.ItemFromJSON([]byte(fixture))
+		if err != nil {
+			t.Fatalf("error building fixture: %v", err)
+		}
+
+		result, err := plugintest.ExecuteRestoreItemAction(action, item, plugintest.NewRestore("conformance"))
+		if err != nil {
+			t.Fatalf("error executing action: %v", err)
+		}
+		if result.Skipped {
+			t.Fatal("action's selector did not match an apps/v1 Deployment")
+		}
+
+		content := result.Item.UnstructuredContent()
+
+		replicas, _, err := unstructured.NestedInt64(content, "spec", "replicas")
+		if err != nil {
+			t.Fatalf("error getting .spec.replicas: %v", err)
+		}
+		if replicas != 5 {
+			t.Errorf(".spec.replicas = %d, want 5", replicas)
+		}
+
+		annotations, _, err := unstructured.NestedStringMap(content, "metadata", "annotations")
+		if err != nil {
+			t.Fatalf("error getting annotations: %v", err)
+		}
+		if val, ok := annotations[controller.OriginalReplicasAnnotation]; ok {
+			t.Errorf("%s annotation %q should have been removed", controller.OriginalReplicasAnnotation, val)
+		}
+	})
+
+	t.Run("items without the annotation are unmodified", func(t *testing.T) {
+		item, err := plugintest.ItemFromJSON([]byte(deploymentFixture("web", "untouched", 2)))
+		if err != nil {
+			t.Fatalf("error building fixture: %v", err)
+		}
+
+		result, err := plugintest.ExecuteRestoreItemAction(action, item, plugintest.NewRestore("conformance"))
+		if err != nil {
+			t.Fatalf("error executing action: %v", err)
+		}
+		if result.Skipped {
+			return
+		}
+
+		replicas, _, err := unstructured.NestedInt64(result.Item.UnstructuredContent(), "spec", "replicas")
+		if err != nil {
+			t.Fatalf("error getting .spec.replicas: %v", err)
+		}
+		if replicas != 2 {
+			t.Errorf(".spec.replicas = %d, want 2", replicas)
+		}
+	})
+}
+
+// RunRoundTripConformance verifies that restoring a backed-up item yields
+// the original replica count.
+func RunRoundTripConformance(t *testing.T, backupAction backup.ItemAction, restoreAction restore.ItemAction) {
+	item, err := plugintest.ItemFromJSON([]byte(deploymentFixture("web", "round-trip", 7)))
+	if err != nil {
+		t.Fatalf("error building fixture: %v", err)
+	}
+
+	backupResult, err := plugintest.ExecuteBackupItemAction(backupAction, item, plugintest.NewBackup("conformance"))
+	if err != nil {
+		t.Fatalf("error executing backup action: %v", err)
+	}
+	if backupResult.Skipped {
+		t.Fatal("backup action's selector did not match an apps/v1 Deployment")
+	}
+
+	restoreResult, err := plugintest.ExecuteRestoreItemAction(restoreAction, backupResult.Item, plugintest.NewRestore("conformance"))
+	if err != nil {
+		t.Fatalf("error executing restore action: %v", err)
+	}
+	if restoreResult.Skipped {
+		t.Fatal("restore action's selector did not match an apps/v1 Deployment")
+	}
+
+	replicas, found, err := unstructured.NestedInt64(restoreResult.Item.UnstructuredContent(), "spec", "replicas")
+	if err != nil || !found {
+		t.Fatalf("missing .spec.replicas after round trip (err: %v)", err)
+	}
+	if replicas != 7 {
+		t.Errorf(".spec.replicas = %d after round trip, want 7", replicas)
+	}
+}
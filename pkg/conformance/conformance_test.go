@@ -0,0 +1,123 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conformance
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	arkv1 "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/restore"
+
+	"github.com/skriss/ark-scale-deployment/pkg/controller"
+	"github.com/skriss/ark-scale-deployment/pkg/plugintest"
+)
+
+// referenceBackupAction is a minimal known-good implementation of the
+// backup-side contract: record the original replica count in the
+// original-replicas annotation and zero .spec.replicas. The suite must
+// pass against it; the shipped plugins run the suite from their own
+// packages.
+type referenceBackupAction struct{}
+
+func (a *referenceBackupAction) AppliesTo() (backup.ResourceSelector, error) {
+	return backup.ResourceSelector{}, nil
+}
+
+func (a *referenceBackupAction) Execute(item runtime.Unstructured, arkBackup *arkv1.Backup) (runtime.Unstructured, []backup.ResourceIdentifier, error) {
+	obj := item.(*unstructured.Unstructured)
+
+	replicas, found, err := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !found {
+		return obj, nil, nil
+	}
+
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[controller.OriginalReplicasAnnotation] = strconv.FormatInt(replicas, 10)
+	obj.SetAnnotations(annotations)
+
+	if err := unstructured.SetNestedField(obj.Object, int64(0), "spec", "replicas"); err != nil {
+		return nil, nil, err
+	}
+
+	return obj, nil, nil
+}
+
+// referenceRestoreAction is the matching restore-side implementation:
+// honor and remove the original-replicas annotation, leave everything
+// else alone.
+type referenceRestoreAction struct{}
+
+func (a *referenceRestoreAction) AppliesTo() (restore.ResourceSelector, error) {
+	return restore.ResourceSelector{}, nil
+}
+
+func (a *referenceRestoreAction) Execute(item runtime.Unstructured, arkRestore *arkv1.Restore) (runtime.Unstructured, error, error) {
+	obj := item.(*unstructured.Unstructured)
+
+	annotations := obj.GetAnnotations()
+	original, ok := annotations[controller.OriginalReplicasAnnotation]
+	if !ok {
+		return obj, nil, nil
+	}
+
+	replicas, err := strconv.ParseInt(original, 10, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	delete(annotations, controller.OriginalReplicasAnnotation)
+	obj.SetAnnotations(annotations)
+
+	if err := unstructured.SetNestedField(obj.Object, replicas, "spec", "replicas"); err != nil {
+		return nil, nil, err
+	}
+
+	return obj, nil, nil
+}
+
+// TestFixturesDecodeLikeACluster pins the fixture decoding the suite
+// depends on: .spec.replicas must come out as int64, the type the actions
+// see for items read from a real cluster.
+func TestFixturesDecodeLikeACluster(t *testing.T) {
+	item, err := plugintest.ItemFromJSON([]byte(deploymentFixture("web", "frontend", 3)))
+	require.NoError(t, err)
+
+	replicas, found, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, int64(3), replicas)
+}
+
+func TestReferenceImplementationConformance(t *testing.T) {
+	RunBackupConformance(t, &referenceBackupAction{})
+	RunRestoreConformance(t, &referenceRestoreAction{})
+	RunRoundTripConformance(t, &referenceBackupAction{}, &referenceRestoreAction{})
+}
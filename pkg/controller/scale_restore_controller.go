@@ -0,0 +1,576 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkinformers "github.com/heptio/ark/pkg/generated/informers/externalversions/ark/v1"
+	arklisters "github.com/heptio/ark/pkg/generated/listers/ark/v1"
+
+	"github.com/skriss/ark-scale-deployment/pkg/quiesce"
+)
+
+const (
+	// OriginalReplicasAnnotation records the replica count a workload had
+	// before it was scaled down for a backup. The scale-restore controller
+	// uses it to perform the staged scale-up after a restore.
+	OriginalReplicasAnnotation = "scale-deployment.ark.heptio.com/original-replicas"
+
+	// RestoreDelayAnnotation makes the controller wait the given duration
+	// (e.g. "120s") before scaling the workload up, for apps that need
+	// caches or dependencies warm first. Workloads are scaled up
+	// sequentially, so a delay also pushes back the workloads after it.
+	RestoreDelayAnnotation = "scale-deployment.ark.heptio.com/restore-delay"
+
+	// OrdinalScaleUpAnnotation makes the controller scale a restored
+	// StatefulSet up one ordinal at a time, waiting for each pod to become
+	// ready, since parallel scale-up of restored StatefulSets can corrupt
+	// quorum-based apps.
+	OrdinalScaleUpAnnotation = "scale-deployment.ark.heptio.com/ordinal-scale-up"
+
+	// scaleRestoreGroup/Version/Resource identify the companion CR on
+	// which the controller reports its per-restore status.
+	scaleRestoreAPIVersion = "scale-deployment.ark.heptio.com/v1"
+	scaleRestoreResource   = "scalerestores"
+
+	healthPollInterval = 5 * time.Second
+	healthPollTimeout  = 10 * time.Minute
+)
+
+// ScaleRestoreController watches Restore objects and, once a restore has
+// completed and the restored workloads' PVCs are healthy, scales the
+// workloads back up to their recorded original replica counts. Progress and
+// per-workload outcomes are reported on a companion ScaleRestore CR, and
+// failed restores are retried with backoff via the workqueue.
+type ScaleRestoreController struct {
+	log           logrus.FieldLogger
+	kubeClient    kubernetes.Interface
+	clientPool    dynamic.ClientPool
+	restoreLister arklisters.RestoreLister
+	queue         workqueue.RateLimitingInterface
+	cacheSyncs    []cache.InformerSynced
+}
+
+// NewScaleRestoreController constructs a ScaleRestoreController.
+func NewScaleRestoreController(
+	log logrus.FieldLogger,
+	kubeClient kubernetes.Interface,
+	clientPool dynamic.ClientPool,
+	restoreInformer arkinformers.RestoreInformer,
+) *ScaleRestoreController {
+	c := &ScaleRestoreController{
+		log:           log,
+		kubeClient:    kubeClient,
+		clientPool:    clientPool,
+		restoreLister: restoreInformer.Lister(),
+		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "scale-restore"),
+		cacheSyncs:    []cache.InformerSynced{restoreInformer.Informer().HasSynced},
+	}
+
+	restoreInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: c.enqueue,
+			UpdateFunc: func(_, obj interface{}) {
+				c.enqueue(obj)
+			},
+		},
+	)
+
+	return c
+}
+
+func (c *ScaleRestoreController) enqueue(obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.log.WithError(err).Error("Error creating queue key, item not added to queue")
+		return
+	}
+	c.queue.Add(key)
+}
+
+// Run starts the controller's workers and blocks until stopCh is closed.
+func (c *ScaleRestoreController) Run(workers int, stopCh <-chan struct{}) error {
+	defer c.queue.ShutDown()
+
+	c.log.Info("Waiting for caches to sync")
+	if !cache.WaitForCacheSync(stopCh, c.cacheSyncs...) {
+		return errors.New("timed out waiting for caches to sync")
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (c *ScaleRestoreController) runWorker() {
+	for c.processNextItem() {
+	}
+}
+
+func (c *ScaleRestoreController) processNextItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.processRestore(key.(string)); err != nil {
+		c.log.WithError(err).WithField("restore", key).Error("Error processing restore, requeuing")
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// processRestore performs the staged scale-up for a completed restore.
+func (c *ScaleRestoreController) processRestore(key string) error {
+	ns, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return errors.Wrap(err, "error splitting queue key")
+	}
+
+	restore, err := c.restoreLister.Restores(ns).Get(name)
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "error getting restore")
+	}
+
+	if restore.Status.Phase != arkv1api.RestorePhaseCompleted {
+		return nil
+	}
+
+	report, err := c.getOrCreateReport(restore)
+	if err != nil {
+		return err
+	}
+	if phase, _, _ := unstructured.NestedString(report.UnstructuredContent(), "status", "phase"); phase == "Completed" {
+		return nil
+	}
+
+	log := c.log.WithField("restore", key)
+
+	deployments, err := c.restoredDeployments(restore)
+	if err != nil {
+		return err
+	}
+
+	statefulSets, err := c.restoredStatefulSets(restore)
+	if err != nil {
+		return err
+	}
+
+	// Scale up in dependency order — a workload comes up only after the
+	// workloads it declares a dependency on.
+	var (
+		keys             []string
+		dependsOn        = make(map[string][]string)
+		deploymentByKey  = make(map[string]appsv1.Deployment)
+		statefulSetByKey = make(map[string]appsv1.StatefulSet)
+	)
+	for _, deployment := range deployments {
+		key := deployment.Namespace + "/" + deployment.Name
+		keys = append(keys, key)
+		dependsOn[key] = quiesce.ParseDependsOn(deployment.Namespace, deployment.Annotations)
+		deploymentByKey[key] = deployment
+	}
+	for _, set := range statefulSets {
+		key := set.Namespace + "/" + set.Name
+		keys = append(keys, key)
+		dependsOn[key] = quiesce.ParseDependsOn(set.Namespace, set.Annotations)
+		statefulSetByKey[key] = set
+	}
+
+	if os.Getenv(quiesce.InferDependenciesEnvVar) == "true" {
+		services, err := c.kubeClient.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			return errors.Wrap(err, "error listing services to infer dependencies")
+		}
+		for key, deps := range quiesce.BuildInferredDependencies(deployments, services.Items) {
+			dependsOn[key] = deps
+		}
+	}
+
+	results := make(map[string]interface{})
+	var errs int
+
+	for _, key := range quiesce.OrderForScaleUp(keys, dependsOn) {
+		outcome := "ScaledUp"
+		if deployment, ok := deploymentByKey[key]; ok {
+			if err := c.scaleUp(log, deployment); err != nil {
+				log.WithError(err).Errorf("Error scaling up deployment %s", key)
+				outcome = fmt.Sprintf("Error: %v", err)
+				errs++
+			}
+		} else if err := c.scaleUpStatefulSet(log, statefulSetByKey[key]); err != nil {
+			log.WithError(err).Errorf("Error scaling up statefulset %s", key)
+			outcome = fmt.Sprintf("Error: %v", err)
+			errs++
+		}
+		results[key] = outcome
+	}
+
+	total := len(deployments) + len(statefulSets)
+
+	// The namespaces are serving again; take down any maintenance markers
+	// the backup-side quiesce put up.
+	namespaces := make(map[string]bool)
+	for _, deployment := range deployments {
+		namespaces[deployment.Namespace] = true
+	}
+	for _, set := range statefulSets {
+		namespaces[set.Namespace] = true
+	}
+	for namespace := range namespaces {
+		quiesce.ClearMaintenance(log, c.kubeClient, namespace)
+		quiesce.ClearNamespaceMarker(log, c.kubeClient, namespace)
+	}
+
+	phase := "Completed"
+	if errs > 0 {
+		phase = "PartiallyFailed"
+	}
+
+	if err := c.updateReport(restore, report, phase, results); err != nil {
+		return err
+	}
+
+	if errs > 0 {
+		return errors.Errorf("%d of %d workloads failed to scale up", errs, total)
+	}
+
+	log.Infof("Scaled up %d workloads", total)
+	return nil
+}
+
+// restoredDeployments returns the deployments in the restore's target
+// namespaces that carry the original-replicas annotation.
+func (c *ScaleRestoreController) restoredDeployments(restore *arkv1api.Restore) ([]appsv1.Deployment, error) {
+	// copy before appending: the restore comes from the informer cache, and
+	// appending to its slice in place would mutate the shared object.
+	namespaces := append([]string(nil), restore.Spec.IncludedNamespaces...)
+	for _, target := range restore.Spec.NamespaceMapping {
+		namespaces = append(namespaces, target)
+	}
+	if len(namespaces) == 0 || (len(namespaces) == 1 && namespaces[0] == "*") {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var deployments []appsv1.Deployment
+	for _, namespace := range namespaces {
+		list, err := c.kubeClient.AppsV1().Deployments(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing deployments in namespace %q", namespace)
+		}
+		for _, deployment := range list.Items {
+			if _, ok := deployment.Annotations[OriginalReplicasAnnotation]; ok {
+				deployments = append(deployments, deployment)
+			}
+		}
+	}
+
+	return deployments, nil
+}
+
+// restoredStatefulSets returns the statefulsets in the restore's target
+// namespaces that carry the original-replicas annotation.
+func (c *ScaleRestoreController) restoredStatefulSets(restore *arkv1api.Restore) ([]appsv1.StatefulSet, error) {
+	namespaces := append([]string(nil), restore.Spec.IncludedNamespaces...)
+	for _, target := range restore.Spec.NamespaceMapping {
+		namespaces = append(namespaces, target)
+	}
+	if len(namespaces) == 0 || (len(namespaces) == 1 && namespaces[0] == "*") {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var statefulSets []appsv1.StatefulSet
+	for _, namespace := range namespaces {
+		list, err := c.kubeClient.AppsV1().StatefulSets(namespace).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error listing statefulsets in namespace %q", namespace)
+		}
+		for _, set := range list.Items {
+			if _, ok := set.Annotations[OriginalReplicasAnnotation]; ok {
+				statefulSets = append(statefulSets, set)
+			}
+		}
+	}
+
+	return statefulSets, nil
+}
+
+// scaleUpStatefulSet waits until all of the statefulset's per-ordinal PVCs
+// are bound before restoring its replica count — a partially-provisioned
+// volume set would strand pods at a missing ordinal. With the
+// ordinal-scale-up annotation, replicas are raised one at a time, waiting
+// for each pod to become ready.
+func (c *ScaleRestoreController) scaleUpStatefulSet(log logrus.FieldLogger, set appsv1.StatefulSet) error {
+	originalReplicas, err := strconv.ParseInt(set.Annotations[OriginalReplicasAnnotation], 10, 32)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing %s annotation", OriginalReplicasAnnotation)
+	}
+
+	if err := c.waitForStatefulSetPVCsBound(set, originalReplicas); err != nil {
+		return err
+	}
+
+	if val, ok := set.Annotations[RestoreDelayAnnotation]; ok {
+		delay, err := time.ParseDuration(val)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing %s annotation", RestoreDelayAnnotation)
+		}
+		log.Infof("Waiting %s before scaling up statefulset %s/%s", delay, set.Namespace, set.Name)
+		time.Sleep(delay)
+	}
+
+	if set.Annotations[OrdinalScaleUpAnnotation] == "true" {
+		for replicas := int64(1); replicas < originalReplicas; replicas++ {
+			patch := []byte(fmt.Sprintf(`{"spec":{"replicas":%d}}`, replicas))
+			if _, err := c.kubeClient.AppsV1().StatefulSets(set.Namespace).Patch(set.Name, types.MergePatchType, patch); err != nil {
+				return errors.Wrapf(err, "error scaling statefulset to %d replicas", replicas)
+			}
+
+			if err := c.waitForStatefulSetReady(set, replicas); err != nil {
+				return err
+			}
+			log.Infof("Statefulset %s/%s ready at %d of %d replicas", set.Namespace, set.Name, replicas, originalReplicas)
+		}
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:null,%q:null}},"spec":{"replicas":%d}}`,
+		OriginalReplicasAnnotation, quiesce.QuiescedByAnnotation, originalReplicas,
+	))
+
+	if _, err := c.kubeClient.AppsV1().StatefulSets(set.Namespace).Patch(set.Name, types.MergePatchType, patch); err != nil {
+		return errors.Wrap(err, "error patching statefulset")
+	}
+
+	log.Infof("Scaled up statefulset %s/%s to %d replicas", set.Namespace, set.Name, originalReplicas)
+	return nil
+}
+
+// waitForStatefulSetPVCsBound polls until the PVC for every volume claim
+// template at every ordinal up to replicas is bound, or the timeout
+// elapses.
+func (c *ScaleRestoreController) waitForStatefulSetPVCsBound(set appsv1.StatefulSet, replicas int64) error {
+	var claims []string
+	for _, template := range set.Spec.VolumeClaimTemplates {
+		for ordinal := int64(0); ordinal < replicas; ordinal++ {
+			claims = append(claims, fmt.Sprintf("%s-%s-%d", template.Name, set.Name, ordinal))
+		}
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+
+	return wait.PollImmediate(healthPollInterval, healthPollTimeout, func() (bool, error) {
+		for _, claim := range claims {
+			pvc, err := c.kubeClient.CoreV1().PersistentVolumeClaims(set.Namespace).Get(claim, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			if pvc.Status.Phase != corev1.ClaimBound {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// waitForStatefulSetReady polls until the statefulset reports at least
+// replicas ready replicas, or the timeout elapses.
+func (c *ScaleRestoreController) waitForStatefulSetReady(set appsv1.StatefulSet, replicas int64) error {
+	return wait.PollImmediate(healthPollInterval, healthPollTimeout, func() (bool, error) {
+		current, err := c.kubeClient.AppsV1().StatefulSets(set.Namespace).Get(set.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return int64(current.Status.ReadyReplicas) >= replicas, nil
+	})
+}
+
+// scaleUp waits for the deployment's PVCs to be bound and any configured
+// restore delay to elapse, then scales it back up to its original replica
+// count and clears the bookkeeping annotations.
+func (c *ScaleRestoreController) scaleUp(log logrus.FieldLogger, deployment appsv1.Deployment) error {
+	originalReplicas, err := strconv.ParseInt(deployment.Annotations[OriginalReplicasAnnotation], 10, 32)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing %s annotation", OriginalReplicasAnnotation)
+	}
+
+	if err := c.waitForPVCsBound(deployment); err != nil {
+		return err
+	}
+
+	if val, ok := deployment.Annotations[RestoreDelayAnnotation]; ok {
+		delay, err := time.ParseDuration(val)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing %s annotation", RestoreDelayAnnotation)
+		}
+		log.Infof("Waiting %s before scaling up deployment %s/%s", delay, deployment.Namespace, deployment.Name)
+		time.Sleep(delay)
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:null,%q:null}},"spec":{"replicas":%d}}`,
+		OriginalReplicasAnnotation, quiesce.QuiescedByAnnotation, originalReplicas,
+	))
+
+	if _, err := c.kubeClient.AppsV1().Deployments(deployment.Namespace).Patch(deployment.Name, types.MergePatchType, patch); err != nil {
+		return errors.Wrap(err, "error patching deployment")
+	}
+
+	log.Infof("Scaled up deployment %s/%s to %d replicas", deployment.Namespace, deployment.Name, originalReplicas)
+	return nil
+}
+
+// waitForPVCsBound polls until every PVC referenced by the deployment's pod
+// template is bound, or the timeout elapses.
+func (c *ScaleRestoreController) waitForPVCsBound(deployment appsv1.Deployment) error {
+	var claims []string
+	for _, volume := range deployment.Spec.Template.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil {
+			claims = append(claims, volume.PersistentVolumeClaim.ClaimName)
+		}
+	}
+	if len(claims) == 0 {
+		return nil
+	}
+
+	return wait.PollImmediate(healthPollInterval, healthPollTimeout, func() (bool, error) {
+		for _, claim := range claims {
+			pvc, err := c.kubeClient.CoreV1().PersistentVolumeClaims(deployment.Namespace).Get(claim, metav1.GetOptions{})
+			if err != nil {
+				return false, nil
+			}
+			if pvc.Status.Phase != corev1.ClaimBound {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+}
+
+// getOrCreateReport returns the companion ScaleRestore CR for the restore,
+// creating it if necessary.
+func (c *ScaleRestoreController) getOrCreateReport(restore *arkv1api.Restore) (*unstructured.Unstructured, error) {
+	client, err := c.reportClient(restore.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := client.Get(restore.Name, metav1.GetOptions{})
+	if err == nil {
+		return report, nil
+	}
+	if !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "error getting scalerestore")
+	}
+
+	report = &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": scaleRestoreAPIVersion,
+			"kind":       "ScaleRestore",
+			"metadata": map[string]interface{}{
+				"namespace": restore.Namespace,
+				"name":      restore.Name,
+			},
+			"spec": map[string]interface{}{
+				"restore": restore.Name,
+			},
+			"status": map[string]interface{}{
+				"phase": "InProgress",
+			},
+		},
+	}
+
+	created, err := client.Create(report)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating scalerestore")
+	}
+
+	return created, nil
+}
+
+// updateReport records the per-workload outcomes and final phase on the
+// companion ScaleRestore CR.
+func (c *ScaleRestoreController) updateReport(restore *arkv1api.Restore, report *unstructured.Unstructured, phase string, results map[string]interface{}) error {
+	client, err := c.reportClient(restore.Namespace)
+	if err != nil {
+		return err
+	}
+
+	if err := unstructured.SetNestedField(report.UnstructuredContent(), phase, "status", "phase"); err != nil {
+		return errors.Wrap(err, "error setting status.phase")
+	}
+	if err := unstructured.SetNestedMap(report.UnstructuredContent(), results, "status", "workloads"); err != nil {
+		return errors.Wrap(err, "error setting status.workloads")
+	}
+
+	if _, err := client.Update(report); err != nil {
+		return errors.Wrap(err, "error updating scalerestore")
+	}
+
+	return nil
+}
+
+func (c *ScaleRestoreController) reportClient(namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(scaleRestoreAPIVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing apiVersion %q", scaleRestoreAPIVersion)
+	}
+
+	client, err := c.clientPool.ClientForGroupVersionKind(gv.WithKind(""))
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting dynamic client for scalerestores")
+	}
+
+	apiResource := &metav1.APIResource{
+		Name:       scaleRestoreResource,
+		Namespaced: true,
+	}
+
+	return client.Resource(apiResource, namespace), nil
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+
+	arkv1api "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkfake "github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+	arkinformers "github.com/heptio/ark/pkg/generated/informers/externalversions"
+	arktest "github.com/heptio/ark/pkg/util/test"
+
+	"github.com/skriss/ark-scale-deployment/pkg/quiesce"
+)
+
+func annotatedDeployment(namespace, name, originalReplicas string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Annotations: map[string]string{
+				OriginalReplicasAnnotation: originalReplicas,
+			},
+		},
+	}
+}
+
+func newTestController(t *testing.T, objects ...runtime.Object) (*ScaleRestoreController, *kubefake.Clientset) {
+	kubeClient := kubefake.NewSimpleClientset(objects...)
+	sharedInformers := arkinformers.NewSharedInformerFactory(arkfake.NewSimpleClientset(), 0)
+
+	c := NewScaleRestoreController(
+		arktest.NewLogger(),
+		kubeClient,
+		nil,
+		sharedInformers.Ark().V1().Restores(),
+	)
+
+	return c, kubeClient
+}
+
+func TestRestoredDeployments(t *testing.T) {
+	c, _ := newTestController(t,
+		annotatedDeployment("ns-1", "annotated", "3"),
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ns-1", Name: "unannotated"},
+		},
+		annotatedDeployment("ns-2", "other-namespace", "2"),
+	)
+
+	tests := []struct {
+		name     string
+		spec     arkv1api.RestoreSpec
+		expected []string
+	}{
+		{
+			name:     "only annotated deployments in included namespaces are returned",
+			spec:     arkv1api.RestoreSpec{IncludedNamespaces: []string{"ns-1"}},
+			expected: []string{"ns-1/annotated"},
+		},
+		{
+			name:     "asterisk matches all namespaces",
+			spec:     arkv1api.RestoreSpec{IncludedNamespaces: []string{"*"}},
+			expected: []string{"ns-1/annotated", "ns-2/other-namespace"},
+		},
+		{
+			name:     "namespace mapping targets are included",
+			spec:     arkv1api.RestoreSpec{IncludedNamespaces: []string{"ns-1"}, NamespaceMapping: map[string]string{"ns-1": "ns-2"}},
+			expected: []string{"ns-1/annotated", "ns-2/other-namespace"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			restore := &arkv1api.Restore{
+				ObjectMeta: metav1.ObjectMeta{Namespace: arkv1api.DefaultNamespace, Name: "restore-1"},
+				Spec:       test.spec,
+			}
+
+			deployments, err := c.restoredDeployments(restore)
+			require.NoError(t, err)
+
+			var keys []string
+			for _, deployment := range deployments {
+				keys = append(keys, deployment.Namespace+"/"+deployment.Name)
+			}
+			assert.Equal(t, test.expected, keys)
+		})
+	}
+}
+
+func TestScaleUp(t *testing.T) {
+	deployment := annotatedDeployment("ns-1", "web", "3")
+	c, kubeClient := newTestController(t, deployment)
+
+	require.NoError(t, c.scaleUp(c.log, *deployment))
+
+	expectedPatch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:null,%q:null}},"spec":{"replicas":3}}`,
+		OriginalReplicasAnnotation, quiesce.QuiescedByAnnotation,
+	))
+
+	var patches []core.PatchAction
+	for _, action := range kubeClient.Actions() {
+		if patch, ok := action.(core.PatchActionImpl); ok {
+			patches = append(patches, patch)
+		}
+	}
+	require.Len(t, patches, 1)
+	assert.Equal(t, "ns-1", patches[0].GetNamespace())
+	assert.Equal(t, "web", patches[0].GetName())
+	assert.Equal(t, expectedPatch, patches[0].GetPatch())
+}
+
+func TestScaleUpInvalidAnnotation(t *testing.T) {
+	deployment := annotatedDeployment("ns-1", "web", "not-a-number")
+	c, kubeClient := newTestController(t, deployment)
+
+	assert.Error(t, c.scaleUp(c.log, *deployment))
+
+	for _, action := range kubeClient.Actions() {
+		assert.False(t, action.Matches("patch", "deployments"))
+	}
+}
+
+func TestScaleUpStatefulSet(t *testing.T) {
+	set := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "ns-1",
+			Name:      "db",
+			Annotations: map[string]string{
+				OriginalReplicasAnnotation: "2",
+			},
+		},
+	}
+	c, kubeClient := newTestController(t, set)
+
+	require.NoError(t, c.scaleUpStatefulSet(c.log, *set))
+
+	var patches []core.PatchAction
+	for _, action := range kubeClient.Actions() {
+		if patch, ok := action.(core.PatchActionImpl); ok {
+			patches = append(patches, patch)
+		}
+	}
+	require.Len(t, patches, 1)
+	assert.Equal(t, "db", patches[0].GetName())
+	assert.Contains(t, string(patches[0].GetPatch()), `"replicas":2`)
+}
+
+func TestEnqueue(t *testing.T) {
+	c, _ := newTestController(t)
+
+	c.enqueue(&arkv1api.Restore{
+		ObjectMeta: metav1.ObjectMeta{Namespace: arkv1api.DefaultNamespace, Name: "restore-1"},
+	})
+
+	require.Equal(t, 1, c.queue.Len())
+
+	key, _ := c.queue.Get()
+	assert.Equal(t, arkv1api.DefaultNamespace+"/restore-1", key.(string))
+}
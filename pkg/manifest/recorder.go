@@ -0,0 +1,191 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/cloudprovider"
+	"github.com/heptio/ark/pkg/cloudprovider/aws"
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+)
+
+// Environment variables configuring where quiesce manifests are uploaded.
+// When a bucket is configured, a small JSON manifest of the workloads
+// quiesced for each backup and their original scales is stored alongside
+// the backup, so restores into a brand-new cluster can recover correct
+// replica counts even if another tool strips the annotations.
+const (
+	ManifestBucketEnvVar   = "ARK_SCALE_MANIFEST_BUCKET"
+	ManifestProviderEnvVar = "ARK_SCALE_MANIFEST_PROVIDER"
+)
+
+const manifestKeyPrefix = "scale-deployment/"
+
+// ObjectStoreFactory constructs the object store used to upload manifests
+// for a given provider.
+type ObjectStoreFactory func() cloudprovider.ObjectStore
+
+// objectStoreFactories maps provider names to object store constructors.
+// Only providers whose SDKs are vendored are registered here; others can be
+// added via RegisterObjectStoreFactory.
+var objectStoreFactories = map[string]ObjectStoreFactory{
+	"aws": aws.NewObjectStore,
+}
+
+// RegisterObjectStoreFactory makes an additional provider available for
+// manifest upload. It is intended to be called from an init function before
+// NewRecorderFromEnv runs.
+func RegisterObjectStoreFactory(provider string, factory ObjectStoreFactory) {
+	objectStoreFactories[provider] = factory
+}
+
+// Manifest records the workloads quiesced for one backup and their original
+// scales.
+type Manifest struct {
+	Backup string `json:"backup"`
+	Items  []Item `json:"items"`
+}
+
+// Item is one quiesced workload.
+type Item struct {
+	GroupResource    string `json:"groupResource"`
+	Namespace        string `json:"namespace"`
+	Name             string `json:"name"`
+	OriginalReplicas int64  `json:"originalReplicas"`
+}
+
+// Recorder accumulates quiesced workloads per backup and uploads the
+// manifest to object storage once the backup reaches a terminal phase.
+type Recorder struct {
+	log         logrus.FieldLogger
+	objectStore cloudprovider.ObjectStore
+	bucket      string
+	arkClient   arkclientset.Interface
+
+	mu        sync.Mutex
+	manifests map[string]*Manifest
+	uploading map[string]bool
+}
+
+// NewRecorderFromEnv returns a Recorder if manifest upload is configured,
+// or nil if it isn't.
+func NewRecorderFromEnv(log logrus.FieldLogger, arkClient arkclientset.Interface) (*Recorder, error) {
+	bucket := os.Getenv(ManifestBucketEnvVar)
+	if bucket == "" {
+		return nil, nil
+	}
+
+	provider := os.Getenv(ManifestProviderEnvVar)
+	factory, ok := objectStoreFactories[provider]
+	if !ok {
+		return nil, errors.Errorf("no object store registered for %s value %q", ManifestProviderEnvVar, provider)
+	}
+
+	objectStore := factory()
+	if err := objectStore.Init(nil); err != nil {
+		return nil, errors.Wrap(err, "error initializing object store")
+	}
+
+	return &Recorder{
+		log:         log,
+		objectStore: objectStore,
+		bucket:      bucket,
+		arkClient:   arkClient,
+		manifests:   make(map[string]*Manifest),
+		uploading:   make(map[string]bool),
+	}, nil
+}
+
+// Record adds a quiesced workload to the backup's manifest and arranges for
+// the manifest to be uploaded when the backup finishes.
+func (r *Recorder) Record(backup *v1.Backup, item Item) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m, ok := r.manifests[backup.Name]
+	if !ok {
+		m = &Manifest{Backup: backup.Name}
+		r.manifests[backup.Name] = m
+	}
+	m.Items = append(m.Items, item)
+
+	if !r.uploading[backup.Name] {
+		r.uploading[backup.Name] = true
+		go r.uploadWhenFinished(backup.Namespace, backup.Name)
+	}
+}
+
+// uploadWhenFinished waits for the backup to reach a terminal phase, then
+// uploads its manifest alongside the backup in object storage.
+func (r *Recorder) uploadWhenFinished(backupNamespace, backupName string) {
+	log := r.log.WithField("backup", backupName)
+
+	err := wait.PollImmediate(10*time.Second, 4*time.Hour, func() (bool, error) {
+		backup, err := r.arkClient.ArkV1().Backups(backupNamespace).Get(backupName, metav1.GetOptions{})
+		if err != nil {
+			log.WithError(err).Warn("Error getting backup, will retry")
+			return false, nil
+		}
+
+		switch backup.Status.Phase {
+		case v1.BackupPhaseCompleted, v1.BackupPhaseFailed, v1.BackupPhaseFailedValidation:
+			return true, nil
+		default:
+			return false, nil
+		}
+	})
+	if err != nil {
+		log.WithError(err).Error("Timed out waiting for backup to finish, not uploading quiesce manifest")
+		return
+	}
+
+	r.mu.Lock()
+	m := r.manifests[backupName]
+	delete(r.manifests, backupName)
+	delete(r.uploading, backupName)
+	r.mu.Unlock()
+
+	if m == nil {
+		return
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.WithError(err).Error("Error encoding quiesce manifest")
+		return
+	}
+
+	key := manifestKeyPrefix + backupName + ".json"
+	if err := r.objectStore.PutObject(r.bucket, key, bytes.NewReader(data)); err != nil {
+		log.WithError(err).Error("Error uploading quiesce manifest")
+		return
+	}
+
+	log.Infof("Uploaded quiesce manifest to %s/%s", r.bucket, key)
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsAddressEnvVar is the address the plugin serves its Prometheus
+// metrics on; metrics are disabled when unset.
+const MetricsAddressEnvVar = "ARK_SCALE_METRICS_ADDR"
+
+const (
+	metricNamespace = "ark_scale"
+
+	quiesceDurationSeconds = "quiesce_duration_seconds"
+
+	namespaceLabel = "namespace"
+)
+
+// ServerMetrics contains the plugin's Prometheus metrics.
+type ServerMetrics struct {
+	quiesceDuration *prometheus.HistogramVec
+}
+
+// NewServerMetrics returns the plugin's metrics collectors.
+func NewServerMetrics() *ServerMetrics {
+	return &ServerMetrics{
+		quiesceDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: metricNamespace,
+				Name:      quiesceDurationSeconds,
+				Help:      "Time from scaling a workload down to its pods being fully terminated, per namespace",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 12),
+			},
+			[]string{namespaceLabel},
+		),
+	}
+}
+
+// RegisterAllMetrics registers all metrics with the default registry.
+func (m *ServerMetrics) RegisterAllMetrics() {
+	prometheus.MustRegister(m.quiesceDuration)
+}
+
+// ObserveQuiesceDuration records how long a workload took from scale-down
+// to fully terminated pods.
+func (m *ServerMetrics) ObserveQuiesceDuration(namespace string, duration time.Duration) {
+	m.quiesceDuration.WithLabelValues(namespace).Observe(duration.Seconds())
+}
+
+// Serve exposes the default registry on addr until the process exits.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
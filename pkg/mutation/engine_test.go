@@ -0,0 +1,202 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutation
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func deployment() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "web",
+				"namespace": "ns-1",
+			},
+			"spec": map[string]interface{}{
+				"replicas": float64(3),
+				"paused":   false,
+			},
+		},
+	}
+}
+
+func newEngine(t *testing.T, rules string) *Engine {
+	if rules == "" {
+		require.NoError(t, os.Unsetenv(RulesEnvVar))
+	} else {
+		require.NoError(t, os.Setenv(RulesEnvVar, rules))
+		defer os.Unsetenv(RulesEnvVar)
+	}
+
+	engine, err := NewEngineFromEnv(arktest.NewLogger())
+	require.NoError(t, err)
+	return engine
+}
+
+func TestEngineDefaultRules(t *testing.T) {
+	engine := newEngine(t, "")
+
+	item := deployment()
+	require.NoError(t, engine.ApplyBackup(item))
+
+	replicas, found, err := unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, float64(0), replicas)
+
+	// the default rule set has no restore patch
+	item = deployment()
+	require.NoError(t, engine.ApplyRestore(item))
+	replicas, _, err = unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), replicas)
+}
+
+func TestEngineMergeRules(t *testing.T) {
+	engine := newEngine(t, `[
+		{
+			"groupKind": "Deployment.apps",
+			"backupPatch": {"spec": {"replicas": 0}},
+			"restorePatch": {"spec": {"paused": true}}
+		}
+	]`)
+
+	item := deployment()
+	require.NoError(t, engine.ApplyBackup(item))
+	replicas, _, err := unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), replicas)
+
+	item = deployment()
+	require.NoError(t, engine.ApplyRestore(item))
+	paused, _, err := unstructured.NestedFieldCopy(item.Object, "spec", "paused")
+	require.NoError(t, err)
+	assert.Equal(t, true, paused)
+}
+
+func TestEngineMergeNullDeletesField(t *testing.T) {
+	engine := newEngine(t, `[
+		{
+			"groupKind": "Deployment.apps",
+			"backupPatch": {"spec": {"paused": null}}
+		}
+	]`)
+
+	item := deployment()
+	require.NoError(t, engine.ApplyBackup(item))
+
+	_, found, err := unstructured.NestedFieldCopy(item.Object, "spec", "paused")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestEngineJSONPatchRules(t *testing.T) {
+	engine := newEngine(t, `[
+		{
+			"groupKind": "Deployment.apps",
+			"type": "json",
+			"backupPatch": [{"op": "replace", "path": "/spec/replicas", "value": 0}]
+		}
+	]`)
+
+	item := deployment()
+	require.NoError(t, engine.ApplyBackup(item))
+
+	replicas, _, err := unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), replicas)
+}
+
+func TestEngineIgnoresOtherGroupKinds(t *testing.T) {
+	engine := newEngine(t, `[
+		{
+			"groupKind": "StatefulSet.apps",
+			"backupPatch": {"spec": {"replicas": 0}}
+		}
+	]`)
+
+	item := deployment()
+	original := item.DeepCopy()
+
+	require.NoError(t, engine.ApplyBackup(item))
+	assert.Equal(t, original, item)
+}
+
+func TestEngineAppliesRulesInOrder(t *testing.T) {
+	engine := newEngine(t, `[
+		{
+			"groupKind": "Deployment.apps",
+			"backupPatch": {"spec": {"replicas": 1}}
+		},
+		{
+			"groupKind": "Deployment.apps",
+			"backupPatch": {"spec": {"replicas": 0}}
+		}
+	]`)
+
+	item := deployment()
+	require.NoError(t, engine.ApplyBackup(item))
+
+	replicas, _, err := unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), replicas)
+}
+
+func TestNewEngineFromEnvErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{
+			name:  "invalid JSON",
+			rules: `[{`,
+		},
+		{
+			name:  "missing groupKind",
+			rules: `[{"backupPatch": {"spec": {"replicas": 0}}}]`,
+		},
+		{
+			name:  "unsupported patch type",
+			rules: `[{"groupKind": "Deployment.apps", "type": "strategic"}]`,
+		},
+		{
+			name:  "malformed JSON patch",
+			rules: `[{"groupKind": "Deployment.apps", "type": "json", "backupPatch": {"spec": {}}}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.NoError(t, os.Setenv(RulesEnvVar, test.rules))
+			defer os.Unsetenv(RulesEnvVar)
+
+			_, err := NewEngineFromEnv(arktest.NewLogger())
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutation
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// StripServerFieldsEnvVar enables scrubbing server-populated fields from
+// backed-up items, so restored objects are clean and diffs against the
+// manifests in Git are meaningful.
+const StripServerFieldsEnvVar = "ARK_SCALE_STRIP_SERVER_FIELDS"
+
+// serverPopulatedMetadataFields are set by the API server rather than the
+// object's author.
+var serverPopulatedMetadataFields = []string{
+	"creationTimestamp",
+	"generation",
+	"resourceVersion",
+	"selfLink",
+	"uid",
+}
+
+// serverPopulatedAnnotations are maintained by controllers or kubectl
+// rather than the object's author.
+var serverPopulatedAnnotations = []string{
+	"deployment.kubernetes.io/revision",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// stripServerFields removes .status and server-populated metadata fields
+// from the item.
+func stripServerFields(item runtime.Unstructured) {
+	content := item.UnstructuredContent()
+
+	unstructured.RemoveNestedField(content, "status")
+	for _, field := range serverPopulatedMetadataFields {
+		unstructured.RemoveNestedField(content, "metadata", field)
+	}
+	for _, annotation := range serverPopulatedAnnotations {
+		unstructured.RemoveNestedField(content, "metadata", "annotations", annotation)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mutation
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func serverPopulatedDeployment() *unstructured.Unstructured {
+	return &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":              "web",
+				"namespace":         "ns-1",
+				"creationTimestamp": "2018-08-01T00:00:00Z",
+				"generation":        float64(2),
+				"resourceVersion":   "12345",
+				"selfLink":          "/apis/apps/v1/namespaces/ns-1/deployments/web",
+				"uid":               "f8e9b8f0-0000-0000-0000-000000000000",
+				"annotations": map[string]interface{}{
+					"deployment.kubernetes.io/revision":                "3",
+					"kubectl.kubernetes.io/last-applied-configuration": "{}",
+					"keep-me": "yes",
+				},
+			},
+			"spec": map[string]interface{}{
+				"replicas": float64(3),
+			},
+			"status": map[string]interface{}{
+				"readyReplicas": float64(3),
+			},
+		},
+	}
+}
+
+func TestStripServerFields(t *testing.T) {
+	item := serverPopulatedDeployment()
+
+	stripServerFields(item)
+
+	_, found, err := unstructured.NestedFieldCopy(item.Object, "status")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	for _, field := range serverPopulatedMetadataFields {
+		_, found, err := unstructured.NestedFieldCopy(item.Object, "metadata", field)
+		require.NoError(t, err)
+		assert.False(t, found, "metadata.%s should be stripped", field)
+	}
+
+	annotations, found, err := unstructured.NestedFieldCopy(item.Object, "metadata", "annotations")
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, map[string]interface{}{"keep-me": "yes"}, annotations)
+
+	// author-controlled fields are untouched
+	name, _, err := unstructured.NestedString(item.Object, "metadata", "name")
+	require.NoError(t, err)
+	assert.Equal(t, "web", name)
+	replicas, _, err := unstructured.NestedFieldCopy(item.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(3), replicas)
+}
+
+func TestEngineStripsServerFieldsOnBackup(t *testing.T) {
+	require.NoError(t, os.Setenv(StripServerFieldsEnvVar, "true"))
+	defer os.Unsetenv(StripServerFieldsEnvVar)
+
+	engine, err := NewEngineFromEnv(arktest.NewLogger())
+	require.NoError(t, err)
+
+	item := serverPopulatedDeployment()
+	require.NoError(t, engine.ApplyBackup(item))
+
+	_, found, err := unstructured.NestedFieldCopy(item.Object, "status")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// stripping only applies to the backed-up copy
+	item = serverPopulatedDeployment()
+	require.NoError(t, engine.ApplyRestore(item))
+	_, found, err = unstructured.NestedFieldCopy(item.Object, "status")
+	require.NoError(t, err)
+	assert.True(t, found)
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify posts outbound webhook notifications when live quiesce of
+// a namespace starts and completes, so app teams learn their services were
+// intentionally scaled down by a backup rather than discovering it from
+// alerts.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookURLEnvVar is the URL notifications are POSTed to; unset
+	// disables notifications.
+	WebhookURLEnvVar = "ARK_SCALE_NOTIFY_WEBHOOK"
+
+	// WebhookFormatEnvVar selects the payload format: "json" (the
+	// default) or "slack" for a Slack incoming-webhook message.
+	WebhookFormatEnvVar = "ARK_SCALE_NOTIFY_FORMAT"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Notifier sends quiesce lifecycle notifications. Items reach the plugin
+// grouped by namespace, so a namespace is considered complete when the
+// first workload of a different namespace starts quiescing; Flush reports
+// the final namespace at shutdown. Notifications are best-effort: failures
+// are logged, never surfaced into the backup.
+type Notifier struct {
+	log    logrus.FieldLogger
+	url    string
+	slack  bool
+	client *http.Client
+
+	mu sync.Mutex
+	// started dedupes start notifications per backup/namespace; lastKey
+	// is the namespace currently quiescing.
+	started map[string]bool
+	lastKey string
+}
+
+// NewNotifierFromEnv returns a Notifier for the configured webhook, or nil
+// if none is configured.
+func NewNotifierFromEnv(log logrus.FieldLogger) (*Notifier, error) {
+	url := os.Getenv(WebhookURLEnvVar)
+	if url == "" {
+		return nil, nil
+	}
+
+	format := os.Getenv(WebhookFormatEnvVar)
+	switch format {
+	case "", "json", "slack":
+	default:
+		return nil, errors.Errorf("invalid %s %q: expected json or slack", WebhookFormatEnvVar, format)
+	}
+
+	return &Notifier{
+		log:     log,
+		url:     url,
+		slack:   format == "slack",
+		client:  &http.Client{Timeout: webhookTimeout},
+		started: make(map[string]bool),
+	}, nil
+}
+
+// QuiesceStarting notifies that live quiesce of the workload's namespace
+// is starting, once per backup and namespace, and reports the previous
+// namespace as complete. Safe to call on a nil Notifier.
+func (n *Notifier) QuiesceStarting(backupName, namespace string) {
+	if n == nil {
+		return
+	}
+
+	key := backupName + "/" + namespace
+
+	n.mu.Lock()
+	if n.started[key] {
+		n.mu.Unlock()
+		return
+	}
+	n.started[key] = true
+	previous := n.lastKey
+	n.lastKey = key
+	n.mu.Unlock()
+
+	if previous != "" && previous != key {
+		n.send("quiesce-completed", previous)
+	}
+	n.send("quiesce-started", key)
+}
+
+// Flush reports the namespace currently quiescing, if any, as complete.
+// Call before exiting. Safe to call on a nil Notifier.
+func (n *Notifier) Flush() {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	last := n.lastKey
+	n.lastKey = ""
+	n.mu.Unlock()
+
+	if last != "" {
+		n.send("quiesce-completed", last)
+	}
+}
+
+// send POSTs one event; key is "backup/namespace".
+func (n *Notifier) send(event, key string) {
+	var (
+		backupName string
+		namespace  = key
+	)
+	if idx := bytes.IndexByte([]byte(key), '/'); idx >= 0 {
+		backupName, namespace = key[:idx], key[idx+1:]
+	}
+
+	var payload interface{}
+	if n.slack {
+		verb := "started"
+		if event == "quiesce-completed" {
+			verb = "completed"
+		}
+		payload = map[string]string{
+			"text": fmt.Sprintf("Live quiesce of namespace %q %s for backup %q: workloads are intentionally scaled down.", namespace, verb, backupName),
+		}
+	} else {
+		payload = map[string]string{
+			"event":     event,
+			"backup":    backupName,
+			"namespace": namespace,
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.log.WithError(err).Warn("Error encoding webhook notification")
+		return
+	}
+
+	resp, err := n.client.Post(n.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		n.log.WithError(err).Warnf("Error sending %s notification for namespace %s", event, namespace)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		n.log.Warnf("Webhook returned %s for %s notification for namespace %s", resp.Status, event, namespace)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugintest runs BackupItemAction and RestoreItemAction
+// implementations in-process against fixture objects and fake
+// Backup/Restore CRs, so action behavior under custom configs can be unit
+// tested without the go-plugin gRPC machinery or a cluster.
+package plugintest
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/backup"
+	"github.com/heptio/ark/pkg/restore"
+)
+
+// ItemFromJSON returns an unstructured item from a JSON fixture, decoded
+// through UnstructuredJSONScheme so numbers come out as int64 — the same
+// shape items have when the Ark server reads them from a cluster.
+func ItemFromJSON(data []byte) (runtime.Unstructured, error) {
+	item := &unstructured.Unstructured{}
+	if err := item.UnmarshalJSON(data); err != nil {
+		return nil, errors.Wrap(err, "error decoding fixture")
+	}
+	return item, nil
+}
+
+// NewBackup returns a Backup CR with the given name in the heptio-ark
+// namespace, suitable for passing to backup item actions.
+func NewBackup(name string) *v1.Backup {
+	return &v1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: v1.DefaultNamespace,
+			Name:      name,
+		},
+	}
+}
+
+// NewRestore returns a Restore CR with the given name in the heptio-ark
+// namespace, suitable for passing to restore item actions.
+func NewRestore(name string) *v1.Restore {
+	return &v1.Restore{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: v1.DefaultNamespace,
+			Name:      name,
+		},
+	}
+}
+
+// BackupResult is the outcome of running a backup item action against one
+// item.
+type BackupResult struct {
+	// Skipped is true when the action's AppliesTo selector didn't match
+	// the item, in which case the other fields are unset.
+	Skipped bool
+
+	Item            runtime.Unstructured
+	AdditionalItems []backup.ResourceIdentifier
+}
+
+// RestoreResult is the outcome of running a restore item action against
+// one item.
+type RestoreResult struct {
+	// Skipped is true when the action's AppliesTo selector didn't match
+	// the item, in which case the other fields are unset.
+	Skipped bool
+
+	Item    runtime.Unstructured
+	Warning error
+}
+
+// ExecuteBackupItemAction checks the action's AppliesTo selector against
+// the item, mirroring how the Ark server resolves selectors, and runs
+// Execute if it matches.
+func ExecuteBackupItemAction(action backup.ItemAction, item runtime.Unstructured, arkBackup *v1.Backup) (*BackupResult, error) {
+	selector, err := action.AppliesTo()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting action's selector")
+	}
+
+	matches, err := selectorMatches(restore.ResourceSelector(selector), item)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return &BackupResult{Skipped: true}, nil
+	}
+
+	updatedItem, additionalItems, err := action.Execute(item, arkBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BackupResult{Item: updatedItem, AdditionalItems: additionalItems}, nil
+}
+
+// ExecuteRestoreItemAction checks the action's AppliesTo selector against
+// the item and runs Execute if it matches.
+func ExecuteRestoreItemAction(action restore.ItemAction, item runtime.Unstructured, arkRestore *v1.Restore) (*RestoreResult, error) {
+	selector, err := action.AppliesTo()
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting action's selector")
+	}
+
+	matches, err := selectorMatches(selector, item)
+	if err != nil {
+		return nil, err
+	}
+	if !matches {
+		return &RestoreResult{Skipped: true}, nil
+	}
+
+	updatedItem, warning, err := action.Execute(item, arkRestore)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RestoreResult{Item: updatedItem, Warning: warning}, nil
+}
+
+// selectorMatches applies a ResourceSelector to an item. Resource names
+// are derived by lowercasing and pluralizing the item's kind — an
+// approximation of the server's discovery-based resolution that holds for
+// the resources these plugins handle.
+func selectorMatches(selector restore.ResourceSelector, item runtime.Unstructured) (bool, error) {
+	accessor, err := meta(item)
+	if err != nil {
+		return false, err
+	}
+
+	if len(selector.IncludedNamespaces) > 0 && !containsString(selector.IncludedNamespaces, accessor.namespace) {
+		return false, nil
+	}
+	if containsString(selector.ExcludedNamespaces, accessor.namespace) {
+		return false, nil
+	}
+
+	gv, err := schema.ParseGroupVersion(accessor.apiVersion)
+	if err != nil {
+		return false, errors.Wrapf(err, "error parsing apiVersion %q", accessor.apiVersion)
+	}
+
+	resource := strings.ToLower(accessor.kind) + "s"
+	qualified := resource
+	if gv.Group != "" {
+		qualified = resource + "." + gv.Group
+	}
+
+	if len(selector.IncludedResources) > 0 &&
+		!containsString(selector.IncludedResources, resource) && !containsString(selector.IncludedResources, qualified) {
+		return false, nil
+	}
+	if containsString(selector.ExcludedResources, resource) || containsString(selector.ExcludedResources, qualified) {
+		return false, nil
+	}
+
+	if selector.LabelSelector != "" {
+		parsed, err := labels.Parse(selector.LabelSelector)
+		if err != nil {
+			return false, errors.Wrapf(err, "error parsing label selector %q", selector.LabelSelector)
+		}
+		if !parsed.Matches(labels.Set(accessor.labels)) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+type itemAccessor struct {
+	apiVersion string
+	kind       string
+	namespace  string
+	labels     map[string]string
+}
+
+func meta(item runtime.Unstructured) (*itemAccessor, error) {
+	apiVersion, _, err := unstructured.NestedString(item.UnstructuredContent(), "apiVersion")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .apiVersion")
+	}
+	kind, _, err := unstructured.NestedString(item.UnstructuredContent(), "kind")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .kind")
+	}
+	namespace, _, err := unstructured.NestedString(item.UnstructuredContent(), "metadata", "namespace")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .metadata.namespace")
+	}
+	itemLabels, _, err := unstructured.NestedStringMap(item.UnstructuredContent(), "metadata", "labels")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .metadata.labels")
+	}
+
+	return &itemAccessor{
+		apiVersion: apiVersion,
+		kind:       kind,
+		namespace:  namespace,
+		labels:     itemLabels,
+	}, nil
+}
+
+func containsString(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
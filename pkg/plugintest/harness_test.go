@@ -0,0 +1,189 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugintest
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	arkv1 "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkbackup "github.com/heptio/ark/pkg/backup"
+	arkrestore "github.com/heptio/ark/pkg/restore"
+)
+
+func deploymentItem(t *testing.T) runtime.Unstructured {
+	item, err := ItemFromJSON([]byte(`{
+		"apiVersion": "apps/v1",
+		"kind": "Deployment",
+		"metadata": {
+			"namespace": "ns-1",
+			"name": "web",
+			"labels": {"app": "web"}
+		},
+		"spec": {"replicas": 3}
+	}`))
+	require.NoError(t, err)
+	return item
+}
+
+// fakeBackupAction records whether Execute was called and annotates the
+// item when it runs.
+type fakeBackupAction struct {
+	selector    arkbackup.ResourceSelector
+	selectorErr error
+	executeErr  error
+	executed    bool
+}
+
+func (a *fakeBackupAction) AppliesTo() (arkbackup.ResourceSelector, error) {
+	return a.selector, a.selectorErr
+}
+
+func (a *fakeBackupAction) Execute(item runtime.Unstructured, backup *arkv1.Backup) (runtime.Unstructured, []arkbackup.ResourceIdentifier, error) {
+	a.executed = true
+	if a.executeErr != nil {
+		return nil, nil, a.executeErr
+	}
+
+	obj := item.(*unstructured.Unstructured)
+	obj.SetAnnotations(map[string]string{"executed-for": backup.Name})
+
+	return obj, []arkbackup.ResourceIdentifier{
+		{GroupResource: schema.GroupResource{Resource: "secrets"}, Namespace: "ns-1", Name: "web-creds"},
+	}, nil
+}
+
+func TestExecuteBackupItemAction(t *testing.T) {
+	tests := []struct {
+		name            string
+		selector        arkbackup.ResourceSelector
+		expectedSkipped bool
+	}{
+		{
+			name:            "zero-valued selector matches",
+			selector:        arkbackup.ResourceSelector{},
+			expectedSkipped: false,
+		},
+		{
+			name:            "matching resource and namespace",
+			selector:        arkbackup.ResourceSelector{IncludedResources: []string{"deployments.apps"}, IncludedNamespaces: []string{"ns-1"}},
+			expectedSkipped: false,
+		},
+		{
+			name:            "matching label selector",
+			selector:        arkbackup.ResourceSelector{LabelSelector: "app=web"},
+			expectedSkipped: false,
+		},
+		{
+			name:            "non-matching namespace skips",
+			selector:        arkbackup.ResourceSelector{IncludedNamespaces: []string{"other"}},
+			expectedSkipped: true,
+		},
+		{
+			name:            "excluded resource skips",
+			selector:        arkbackup.ResourceSelector{ExcludedResources: []string{"deployments"}},
+			expectedSkipped: true,
+		},
+		{
+			name:            "non-matching label selector skips",
+			selector:        arkbackup.ResourceSelector{LabelSelector: "app=db"},
+			expectedSkipped: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			action := &fakeBackupAction{selector: test.selector}
+
+			result, err := ExecuteBackupItemAction(action, deploymentItem(t), NewBackup("backup-1"))
+			require.NoError(t, err)
+
+			assert.Equal(t, test.expectedSkipped, result.Skipped)
+			assert.Equal(t, !test.expectedSkipped, action.executed)
+
+			if !test.expectedSkipped {
+				obj := result.Item.(*unstructured.Unstructured)
+				assert.Equal(t, "backup-1", obj.GetAnnotations()["executed-for"])
+				require.Len(t, result.AdditionalItems, 1)
+				assert.Equal(t, "web-creds", result.AdditionalItems[0].Name)
+			}
+		})
+	}
+}
+
+func TestExecuteBackupItemActionErrors(t *testing.T) {
+	_, err := ExecuteBackupItemAction(&fakeBackupAction{selectorErr: errors.New("bad selector")}, deploymentItem(t), NewBackup("backup-1"))
+	assert.Error(t, err)
+
+	_, err = ExecuteBackupItemAction(&fakeBackupAction{executeErr: errors.New("execute failed")}, deploymentItem(t), NewBackup("backup-1"))
+	assert.Error(t, err)
+}
+
+// fakeRestoreAction zeroes the item's replicas and returns a canned
+// warning when it runs.
+type fakeRestoreAction struct {
+	selector arkrestore.ResourceSelector
+	warning  error
+}
+
+func (a *fakeRestoreAction) AppliesTo() (arkrestore.ResourceSelector, error) {
+	return a.selector, nil
+}
+
+func (a *fakeRestoreAction) Execute(item runtime.Unstructured, restore *arkv1.Restore) (runtime.Unstructured, error, error) {
+	obj := item.(*unstructured.Unstructured)
+	if err := unstructured.SetNestedField(obj.Object, float64(0), "spec", "replicas"); err != nil {
+		return nil, nil, err
+	}
+	return obj, a.warning, nil
+}
+
+func TestExecuteRestoreItemAction(t *testing.T) {
+	warning := errors.New("heads up")
+	action := &fakeRestoreAction{warning: warning}
+
+	result, err := ExecuteRestoreItemAction(action, deploymentItem(t), NewRestore("restore-1"))
+	require.NoError(t, err)
+
+	assert.False(t, result.Skipped)
+	assert.Equal(t, warning, result.Warning)
+
+	obj := result.Item.(*unstructured.Unstructured)
+	replicas, _, err := unstructured.NestedFloat64(obj.Object, "spec", "replicas")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), replicas)
+}
+
+func TestExecuteRestoreItemActionSkips(t *testing.T) {
+	action := &fakeRestoreAction{selector: arkrestore.ResourceSelector{IncludedResources: []string{"services"}}}
+
+	result, err := ExecuteRestoreItemAction(action, deploymentItem(t), NewRestore("restore-1"))
+	require.NoError(t, err)
+	assert.True(t, result.Skipped)
+}
+
+func TestItemFromJSONInvalid(t *testing.T) {
+	_, err := ItemFromJSON([]byte(`not json`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+// DependsOnAnnotation declares that a workload depends on another, as a
+// comma-separated list of "namespace/name" (or bare "name" for the same
+// namespace). During a live quiesce, dependents are scaled down before the
+// workloads they depend on — consumers before their message broker — and
+// scaled back up in the opposite order.
+const DependsOnAnnotation = "scale-deployment.ark.heptio.com/depends-on"
+
+// ParseDependsOn returns the "namespace/name" keys of the workloads the
+// annotated object declares dependencies on.
+func ParseDependsOn(namespace string, annotations map[string]string) []string {
+	val, ok := annotations[DependsOnAnnotation]
+	if !ok {
+		return nil
+	}
+
+	var keys []string
+	for _, entry := range strings.Split(val, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			entry = namespace + "/" + entry
+		}
+		keys = append(keys, entry)
+	}
+
+	return keys
+}
+
+// OrderForScaleUp sorts keys so each workload comes after the workloads it
+// depends on, i.e. dependencies are scaled up first. Dependencies outside
+// keys are ignored, and a cycle falls back to input order for the workloads
+// on it rather than failing.
+func OrderForScaleUp(keys []string, dependsOn map[string][]string) []string {
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[key] = true
+	}
+
+	var (
+		order = make([]string, 0, len(keys))
+		// 0 = unvisited, 1 = in progress (a repeat visit means a cycle),
+		// 2 = emitted.
+		state = make(map[string]int, len(keys))
+		visit func(key string)
+	)
+	visit = func(key string) {
+		if state[key] != 0 {
+			return
+		}
+		state[key] = 1
+		for _, dep := range dependsOn[key] {
+			if known[dep] {
+				visit(dep)
+			}
+		}
+		state[key] = 2
+		order = append(order, key)
+	}
+
+	for _, key := range keys {
+		visit(key)
+	}
+
+	return order
+}
+
+// PreQuiescedReplicas returns the replica count a workload had when this
+// process quiesced it eagerly as a dependent of an earlier item, before the
+// workload's own backup item was read.
+func (q *Quiescer) PreQuiescedReplicas(namespace, name string) (int64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	replicas, ok := q.preQuiesced[namespace+"/"+name]
+	return replicas, ok
+}
+
+// quiesceDependents scales down, depth-first, every live deployment that
+// declares a dependency on key, so dependents are fully drained before the
+// workload they depend on goes down. visited breaks cycles and contains the
+// key of the item currently being quiesced.
+func (q *Quiescer) quiesceDependents(backup *v1.Backup, key string, visited map[string]bool) error {
+	dependents, err := q.dependentsOf(key)
+	if err != nil {
+		return err
+	}
+
+	for i := range dependents {
+		dependent := dependents[i]
+		dependentKey := dependent.Namespace + "/" + dependent.Name
+		if visited[dependentKey] {
+			continue
+		}
+		visited[dependentKey] = true
+
+		// Transitive dependents go down first.
+		if err := q.quiesceDependents(backup, dependentKey, visited); err != nil {
+			return err
+		}
+
+		originalReplicas := int64(1)
+		if dependent.Spec.Replicas != nil {
+			originalReplicas = int64(*dependent.Spec.Replicas)
+		}
+		if originalReplicas == 0 || dependent.Annotations[QuiescedByAnnotation] != "" {
+			continue
+		}
+
+		patch := []byte(fmt.Sprintf(
+			`{"metadata":{"annotations":{%q:%q}},"spec":{"replicas":0}}`,
+			QuiescedByAnnotation, backup.Name,
+		))
+		err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, err := q.kubeClient.AppsV1().Deployments(dependent.Namespace).Patch(dependent.Name, types.MergePatchType, patch)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error quiescing dependent deployment %s", dependentKey)
+		}
+
+		q.mu.Lock()
+		if q.preQuiesced == nil {
+			q.preQuiesced = make(map[string]int64)
+		}
+		q.preQuiesced[dependentKey] = originalReplicas
+		q.quiesced = append(q.quiesced, quiescedWorkload{
+			apiVersion:       "apps/v1",
+			namespace:        dependent.Namespace,
+			name:             dependent.Name,
+			originalReplicas: originalReplicas,
+		})
+		q.mu.Unlock()
+
+		q.log.Infof("Quiesced dependent deployment %s ahead of %s", dependentKey, key)
+
+		if dependent.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(dependent.Spec.Selector)
+		if err != nil {
+			continue
+		}
+		err = wait.PollImmediate(terminationPollInterval, terminationPollTimeout, func() (bool, error) {
+			pods, err := q.podLister.Pods(dependent.Namespace).List(selector)
+			if err != nil {
+				return false, errors.Wrap(err, "error listing dependent deployment's pods")
+			}
+			return len(pods) == 0, nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error waiting for dependent deployment %s to terminate", dependentKey)
+		}
+	}
+
+	return nil
+}
+
+// dependentsOf returns the live deployments declaring a dependency on key.
+// The dependency index is built from a single cluster-wide LIST on first
+// use and reused for the life of the process — dependency edges changing
+// mid-backup is not a case worth an informer.
+func (q *Quiescer) dependentsOf(key string) ([]appsv1.Deployment, error) {
+	q.mu.Lock()
+	index := q.dependents
+	q.mu.Unlock()
+
+	if index == nil {
+		list, err := q.kubeClient.AppsV1().Deployments(metav1.NamespaceAll).List(metav1.ListOptions{})
+		if err != nil {
+			return nil, errors.Wrap(err, "error listing deployments to build the dependency index")
+		}
+
+		index = make(map[string][]appsv1.Deployment)
+		for _, deployment := range list.Items {
+			for _, dep := range ParseDependsOn(deployment.Namespace, deployment.Annotations) {
+				index[dep] = append(index[dep], deployment)
+			}
+		}
+
+		if os.Getenv(InferDependenciesEnvVar) == "true" {
+			services, err := q.kubeClient.CoreV1().Services(metav1.NamespaceAll).List(metav1.ListOptions{})
+			if err != nil {
+				return nil, errors.Wrap(err, "error listing services to infer dependencies")
+			}
+
+			deploymentsByKey := make(map[string]appsv1.Deployment, len(list.Items))
+			for _, deployment := range list.Items {
+				deploymentsByKey[deployment.Namespace+"/"+deployment.Name] = deployment
+			}
+
+			for key, deps := range BuildInferredDependencies(list.Items, services.Items) {
+				for _, dep := range deps {
+					index[dep] = append(index[dep], deploymentsByKey[key])
+				}
+			}
+		}
+
+		q.mu.Lock()
+		q.dependents = index
+		q.mu.Unlock()
+	}
+
+	return index[key], nil
+}
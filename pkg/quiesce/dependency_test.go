@@ -0,0 +1,118 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDependsOn(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:        "no annotation",
+			annotations: map[string]string{"other": "x"},
+			expected:    nil,
+		},
+		{
+			name:        "bare names get the object's namespace",
+			annotations: map[string]string{DependsOnAnnotation: "broker"},
+			expected:    []string{"ns-1/broker"},
+		},
+		{
+			name:        "qualified names are kept as-is",
+			annotations: map[string]string{DependsOnAnnotation: "ns-2/broker"},
+			expected:    []string{"ns-2/broker"},
+		},
+		{
+			name:        "multiple entries with whitespace",
+			annotations: map[string]string{DependsOnAnnotation: "broker, ns-2/db , cache"},
+			expected:    []string{"ns-1/broker", "ns-2/db", "ns-1/cache"},
+		},
+		{
+			name:        "empty entries are dropped",
+			annotations: map[string]string{DependsOnAnnotation: "broker,,"},
+			expected:    []string{"ns-1/broker"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ParseDependsOn("ns-1", test.annotations))
+		})
+	}
+}
+
+func TestOrderForScaleUp(t *testing.T) {
+	tests := []struct {
+		name      string
+		keys      []string
+		dependsOn map[string][]string
+		expected  []string
+	}{
+		{
+			name:     "no dependencies keeps input order",
+			keys:     []string{"ns-1/a", "ns-1/b", "ns-1/c"},
+			expected: []string{"ns-1/a", "ns-1/b", "ns-1/c"},
+		},
+		{
+			name: "dependencies come first",
+			keys: []string{"ns-1/consumer", "ns-1/broker"},
+			dependsOn: map[string][]string{
+				"ns-1/consumer": {"ns-1/broker"},
+			},
+			expected: []string{"ns-1/broker", "ns-1/consumer"},
+		},
+		{
+			name: "transitive dependencies are ordered",
+			keys: []string{"ns-1/web", "ns-1/api", "ns-1/db"},
+			dependsOn: map[string][]string{
+				"ns-1/web": {"ns-1/api"},
+				"ns-1/api": {"ns-1/db"},
+			},
+			expected: []string{"ns-1/db", "ns-1/api", "ns-1/web"},
+		},
+		{
+			name: "dependencies outside the key set are ignored",
+			keys: []string{"ns-1/web"},
+			dependsOn: map[string][]string{
+				"ns-1/web": {"ns-2/unrelated"},
+			},
+			expected: []string{"ns-1/web"},
+		},
+		{
+			name: "a cycle falls back to input order",
+			keys: []string{"ns-1/a", "ns-1/b"},
+			dependsOn: map[string][]string{
+				"ns-1/a": {"ns-1/b"},
+				"ns-1/b": {"ns-1/a"},
+			},
+			expected: []string{"ns-1/b", "ns-1/a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, OrderForScaleUp(test.keys, test.dependsOn))
+		})
+	}
+}
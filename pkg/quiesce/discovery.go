@@ -0,0 +1,123 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// InferDependenciesEnvVar enables automatic dependency inference from the
+// service graph: a deployment whose pod template references a Service in
+// an env var — by name or any cluster DNS form — is treated as depending
+// on the deployments that Service selects. Inference only applies to
+// deployments without an explicit depends-on annotation, which always
+// wins.
+const InferDependenciesEnvVar = "ARK_SCALE_INFER_DEPENDENCIES"
+
+// BuildInferredDependencies returns the inferred dependency keys
+// ("namespace/name") for each deployment lacking an explicit depends-on
+// annotation. Self-references are dropped, and a Service without a
+// selector contributes no edges.
+func BuildInferredDependencies(deployments []appsv1.Deployment, services []corev1.Service) map[string][]string {
+	// Resolve each Service to the deployments providing it.
+	providers := make(map[string][]string)
+	for i := range services {
+		service := &services[i]
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		selector := labels.SelectorFromSet(labels.Set(service.Spec.Selector))
+		serviceKey := service.Namespace + "/" + service.Name
+
+		for _, deployment := range deployments {
+			if deployment.Namespace != service.Namespace {
+				continue
+			}
+			if selector.Matches(labels.Set(deployment.Spec.Template.Labels)) {
+				providers[serviceKey] = append(providers[serviceKey], deployment.Namespace+"/"+deployment.Name)
+			}
+		}
+	}
+
+	inferred := make(map[string][]string)
+	for _, deployment := range deployments {
+		if _, ok := deployment.Annotations[DependsOnAnnotation]; ok {
+			continue
+		}
+
+		key := deployment.Namespace + "/" + deployment.Name
+		tokens := envTokens(deployment)
+		seen := make(map[string]bool)
+
+		for i := range services {
+			service := &services[i]
+			if !referencesService(tokens, service, deployment.Namespace) {
+				continue
+			}
+			for _, provider := range providers[service.Namespace+"/"+service.Name] {
+				if provider == key || seen[provider] {
+					continue
+				}
+				seen[provider] = true
+				inferred[key] = append(inferred[key], provider)
+			}
+		}
+	}
+
+	return inferred
+}
+
+// envTokens returns the DNS-shaped tokens appearing in the env var values
+// of the deployment's containers and init containers, lowercased. Values
+// are split on anything that can't appear in a DNS name, so a Service
+// name embedded in a URL like "amqp://broker.messaging:5672" still
+// surfaces as a token.
+func envTokens(deployment appsv1.Deployment) map[string]bool {
+	tokens := make(map[string]bool)
+
+	containers := append([]corev1.Container{}, deployment.Spec.Template.Spec.Containers...)
+	containers = append(containers, deployment.Spec.Template.Spec.InitContainers...)
+
+	for _, container := range containers {
+		for _, env := range container.Env {
+			for _, token := range strings.FieldsFunc(strings.ToLower(env.Value), func(r rune) bool {
+				return !(r == '.' || r == '-' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'))
+			}) {
+				tokens[token] = true
+			}
+		}
+	}
+
+	return tokens
+}
+
+// referencesService reports whether any token matches the Service's name
+// in a form resolvable from the given namespace: the bare name (same
+// namespace only), "name.namespace", or the full svc DNS suffixes.
+func referencesService(tokens map[string]bool, service *corev1.Service, fromNamespace string) bool {
+	qualified := service.Name + "." + service.Namespace
+
+	if service.Namespace == fromNamespace && tokens[service.Name] {
+		return true
+	}
+
+	return tokens[qualified] || tokens[qualified+".svc"] || tokens[qualified+".svc.cluster.local"]
+}
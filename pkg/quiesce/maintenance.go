@@ -0,0 +1,186 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MaintenanceModeEnvVar selects how quiesced namespaces are marked as
+// under maintenance, so user-facing traffic gets a proper maintenance
+// response while replicas are zero: "configmap" creates the marker
+// ConfigMap below, "ingress-annotation" annotates the namespace's
+// Ingresses for an ingress controller configured to serve a maintenance
+// backend. Unset disables the step.
+const MaintenanceModeEnvVar = "ARK_SCALE_MAINTENANCE_MODE"
+
+// MaintenanceMode is a maintenance-marking strategy.
+type MaintenanceMode string
+
+const (
+	// MaintenanceModeOff disables maintenance marking.
+	MaintenanceModeOff MaintenanceMode = ""
+	// MaintenanceModeConfigMap creates MaintenanceConfigMapName in each
+	// quiesced namespace.
+	MaintenanceModeConfigMap MaintenanceMode = "configmap"
+	// MaintenanceModeIngress sets MaintenanceAnnotation on the quiesced
+	// namespace's Ingresses.
+	MaintenanceModeIngress MaintenanceMode = "ingress-annotation"
+)
+
+const (
+	// MaintenanceConfigMapName is the marker ConfigMap's name.
+	MaintenanceConfigMapName = "ark-scale-maintenance"
+
+	// MaintenanceAnnotation marks an Ingress as serving maintenance
+	// traffic; MaintenanceBackupAnnotation records which backup caused it.
+	MaintenanceAnnotation       = "scale-deployment.ark.heptio.com/maintenance"
+	MaintenanceBackupAnnotation = "scale-deployment.ark.heptio.com/maintenance-backup"
+)
+
+// LoadMaintenanceMode returns the configured maintenance mode.
+func LoadMaintenanceMode() (MaintenanceMode, error) {
+	switch mode := MaintenanceMode(os.Getenv(MaintenanceModeEnvVar)); mode {
+	case MaintenanceModeOff, MaintenanceModeConfigMap, MaintenanceModeIngress:
+		return mode, nil
+	default:
+		return MaintenanceModeOff, errors.Errorf("invalid %s %q: expected configmap or ingress-annotation", MaintenanceModeEnvVar, mode)
+	}
+}
+
+// enterMaintenance marks the namespace as under maintenance, once per
+// process. Marking is best-effort: a failure is logged, not surfaced into
+// the backup item.
+func (q *Quiescer) enterMaintenance(namespace, backupName string) {
+	if q.maintenanceMode == MaintenanceModeOff {
+		return
+	}
+
+	q.mu.Lock()
+	if q.maintenanceNamespaces == nil {
+		q.maintenanceNamespaces = make(map[string]bool)
+	}
+	if q.maintenanceNamespaces[namespace] {
+		q.mu.Unlock()
+		return
+	}
+	q.maintenanceNamespaces[namespace] = true
+	q.mu.Unlock()
+
+	var err error
+	switch q.maintenanceMode {
+	case MaintenanceModeConfigMap:
+		err = q.createMaintenanceConfigMap(namespace, backupName)
+	case MaintenanceModeIngress:
+		err = q.annotateIngresses(namespace, backupName)
+	}
+	if err != nil {
+		q.log.WithError(err).Warnf("Error marking namespace %s as under maintenance", namespace)
+		return
+	}
+
+	q.log.Infof("Marked namespace %s as under maintenance for backup %s", namespace, backupName)
+}
+
+// ClearMaintenance removes the namespace's maintenance markers, both
+// kinds, regardless of mode, so a mode change between mark and clear can't
+// strand one. It's shared with the scale-restore controller, which clears
+// markers once a restore has scaled the namespace back up.
+func ClearMaintenance(log logrus.FieldLogger, kubeClient kubernetes.Interface, namespace string) {
+	err := kubeClient.CoreV1().ConfigMaps(namespace).Delete(MaintenanceConfigMapName, nil)
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.WithError(err).Warnf("Error deleting maintenance configmap in namespace %s", namespace)
+	}
+
+	if err := clearIngressAnnotations(kubeClient, namespace); err != nil {
+		log.WithError(err).Warnf("Error clearing maintenance annotations in namespace %s", namespace)
+	}
+}
+
+func (q *Quiescer) createMaintenanceConfigMap(namespace, backupName string) error {
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      MaintenanceConfigMapName,
+		},
+		Data: map[string]string{
+			"backup":  backupName,
+			"since":   time.Now().UTC().Format(time.RFC3339),
+			"message": "Services in this namespace are scaled down for a backup.",
+		},
+	}
+
+	_, err := q.kubeClient.CoreV1().ConfigMaps(namespace).Create(cm)
+	if apierrors.IsAlreadyExists(err) {
+		return nil
+	}
+	return errors.Wrapf(err, "error creating maintenance configmap in namespace %s", namespace)
+}
+
+func (q *Quiescer) annotateIngresses(namespace, backupName string) error {
+	ingresses, err := q.kubeClient.ExtensionsV1beta1().Ingresses(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error listing ingresses in namespace %s", namespace)
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:"true",%q:%q}}}`,
+		MaintenanceAnnotation, MaintenanceBackupAnnotation, backupName,
+	))
+
+	for i := range ingresses.Items {
+		if _, err := q.kubeClient.ExtensionsV1beta1().Ingresses(namespace).Patch(ingresses.Items[i].Name, types.MergePatchType, patch); err != nil {
+			return errors.Wrapf(err, "error annotating ingress %s/%s", namespace, ingresses.Items[i].Name)
+		}
+	}
+
+	return nil
+}
+
+func clearIngressAnnotations(kubeClient kubernetes.Interface, namespace string) error {
+	ingresses, err := kubeClient.ExtensionsV1beta1().Ingresses(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "error listing ingresses in namespace %s", namespace)
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:null,%q:null}}}`,
+		MaintenanceAnnotation, MaintenanceBackupAnnotation,
+	))
+
+	for i := range ingresses.Items {
+		if _, ok := ingresses.Items[i].Annotations[MaintenanceAnnotation]; !ok {
+			continue
+		}
+		if _, err := kubeClient.ExtensionsV1beta1().Ingresses(namespace).Patch(ingresses.Items[i].Name, types.MergePatchType, patch); err != nil {
+			return errors.Wrapf(err, "error clearing maintenance annotations on ingress %s/%s", namespace, ingresses.Items[i].Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// QuiesceExpectedCompletionAnnotation is set on the Namespace object,
+// alongside QuiescedByAnnotation, while any of its workloads are
+// live-quiesced. Other automation — operators, autoscalers, alerting —
+// can use it to detect and respect the quiesce state instead of fighting
+// the scale-down.
+const QuiesceExpectedCompletionAnnotation = "scale-deployment.ark.heptio.com/quiesce-expected-completion"
+
+// markNamespace annotates the Namespace object with the backup name and
+// the time the quiesce is expected to be over, once per process. Marking
+// is best-effort.
+func (q *Quiescer) markNamespace(namespace, backupName string) {
+	q.mu.Lock()
+	if q.markedNamespaces == nil {
+		q.markedNamespaces = make(map[string]bool)
+	}
+	if q.markedNamespaces[namespace] {
+		q.mu.Unlock()
+		return
+	}
+	q.markedNamespaces[namespace] = true
+	q.mu.Unlock()
+
+	expectedCompletion := time.Now().Add(q.drainWait + terminationPollTimeout).UTC().Format(time.RFC3339)
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:%q,%q:%q}}}`,
+		QuiescedByAnnotation, backupName,
+		QuiesceExpectedCompletionAnnotation, expectedCompletion,
+	))
+
+	if _, err := q.kubeClient.CoreV1().Namespaces().Patch(namespace, types.MergePatchType, patch); err != nil {
+		q.log.WithError(err).Warnf("Error annotating namespace %s with quiesce state", namespace)
+		return
+	}
+
+	q.log.Infof("Annotated namespace %s as quiesced by backup %s until ~%s", namespace, backupName, expectedCompletion)
+}
+
+// ClearNamespaceMarker removes the quiesce annotations from the Namespace
+// object. It's shared with the scale-restore controller, which clears them
+// once a restore has scaled the namespace back up.
+func ClearNamespaceMarker(log logrus.FieldLogger, kubeClient kubernetes.Interface, namespace string) {
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:null,%q:null}}}`,
+		QuiescedByAnnotation, QuiesceExpectedCompletionAnnotation,
+	))
+
+	if _, err := kubeClient.CoreV1().Namespaces().Patch(namespace, types.MergePatchType, patch); err != nil {
+		log.WithError(err).Warnf("Error clearing quiesce annotations from namespace %s", namespace)
+	}
+}
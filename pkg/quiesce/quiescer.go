@@ -0,0 +1,595 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+	arkclient "github.com/heptio/ark/pkg/client"
+	arkdiscovery "github.com/heptio/ark/pkg/discovery"
+	"github.com/heptio/ark/pkg/podexec"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/metrics"
+	"github.com/skriss/ark-scale-deployment/pkg/notify"
+	"github.com/skriss/ark-scale-deployment/pkg/trace"
+)
+
+// ForcePDBViolationEnvVar allows live quiesce to scale a workload below a
+// PodDisruptionBudget's minAvailable. Without it, the item fails with an
+// error naming the PDB, so the plugin doesn't silently violate
+// availability contracts.
+const ForcePDBViolationEnvVar = "ARK_SCALE_FORCE_PDB_VIOLATION"
+
+// QuiescedByAnnotation is applied to a live workload when it's scaled down
+// for a backup, and names that backup. The scale-guard admission webhook
+// uses it to reject replica increases while the workload is quiesced.
+const QuiescedByAnnotation = "scale-deployment.ark.heptio.com/quiesced-by"
+
+const (
+	// podBackupNameAnnotation and podBackupTimestampAnnotation are applied
+	// to a workload's pods before a live scale-down so pod terminations can
+	// be correlated with the backup that caused them.
+	podBackupNameAnnotation      = "scale-deployment.ark.heptio.com/backup-name"
+	podBackupTimestampAnnotation = "scale-deployment.ark.heptio.com/backup-timestamp"
+
+	defaultResync = 5 * time.Minute
+
+	terminationPollInterval = time.Second
+	terminationPollTimeout  = 5 * time.Minute
+)
+
+// Quiescer scales live workloads down during a backup. Pods are read from a
+// shared informer cache rather than listed per item, and all writes go
+// through a bounded worker pool, so backups of clusters with thousands of
+// deployments don't issue per-item LIST calls or unbounded concurrent
+// patches. Group/version resolution goes through a cached discovery
+// helper, so items served from apps/v1, apps/v1beta2, or extensions/v1beta1
+// are all handled uniformly without per-item discovery calls.
+type Quiescer struct {
+	log             logrus.FieldLogger
+	kubeClient      kubernetes.Interface
+	podLister       corev1listers.PodLister
+	discoveryHelper arkdiscovery.Helper
+	dynamicFactory  arkclient.DynamicFactory
+	podExecutor     podexec.PodCommandExecutor
+	preHooks        map[string]config.PreHook
+	drainWait       time.Duration
+	drainUsesGrace  bool
+	forcePDB        bool
+	workers         int
+	metrics         *metrics.ServerMetrics
+	tracer          *trace.Tracer
+	notifier        *notify.Notifier
+
+	maintenanceMode MaintenanceMode
+
+	// quiesced records the live scale-downs this process performed, so
+	// they can be undone if it's told to shut down mid-backup;
+	// maintenanceNamespaces the namespaces marked as under maintenance;
+	// markedNamespaces the Namespace objects annotated as quiesced.
+	mu                    sync.Mutex
+	quiesced              []quiescedWorkload
+	maintenanceNamespaces map[string]bool
+	markedNamespaces      map[string]bool
+
+	// dependents indexes live deployments by the "namespace/name" keys
+	// they declare dependencies on; preQuiesced records the original
+	// replica counts of workloads scaled down eagerly as dependents.
+	dependents  map[string][]appsv1.Deployment
+	preQuiesced map[string]int64
+}
+
+// quiescedWorkload identifies one live scale-down and the replica count to
+// restore it to.
+type quiescedWorkload struct {
+	apiVersion       string
+	namespace        string
+	name             string
+	originalReplicas int64
+}
+
+// SetMetrics records quiesce durations on the given metrics; without it,
+// quiescing is not instrumented.
+func (q *Quiescer) SetMetrics(m *metrics.ServerMetrics) {
+	q.metrics = m
+}
+
+// SetTracer emits spans around the quiesce's sub-steps; without it,
+// quiescing is not traced.
+func (q *Quiescer) SetTracer(t *trace.Tracer) {
+	q.tracer = t
+}
+
+// SetNotifier sends quiesce start/complete notifications; without it,
+// nobody is notified.
+func (q *Quiescer) SetNotifier(n *notify.Notifier) {
+	q.notifier = n
+}
+
+// NewQuiescer returns a Quiescer whose informer and discovery caches are
+// synced and ready for use. workers bounds the number of concurrent API
+// writes.
+func NewQuiescer(log logrus.FieldLogger, kubeClient kubernetes.Interface, clientConfig *rest.Config, workers int, stopCh <-chan struct{}) (*Quiescer, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	factory := informers.NewSharedInformerFactory(kubeClient, defaultResync)
+	podInformer := factory.Core().V1().Pods()
+
+	factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, podInformer.Informer().HasSynced) {
+		return nil, errors.New("timed out waiting for pod informer cache to sync")
+	}
+
+	discoveryHelper, err := arkdiscovery.NewHelper(kubeClient.Discovery(), log)
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating discovery helper")
+	}
+
+	preHooks, err := config.LoadPreHooks()
+	if err != nil {
+		return nil, err
+	}
+
+	drainWait, drainUsesGrace, err := config.LoadDrainWait()
+	if err != nil {
+		return nil, err
+	}
+
+	maintenanceMode, err := LoadMaintenanceMode()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Quiescer{
+		log:             log,
+		kubeClient:      kubeClient,
+		podLister:       podInformer.Lister(),
+		discoveryHelper: discoveryHelper,
+		dynamicFactory:  arkclient.NewDynamicFactory(dynamic.NewDynamicClientPool(clientConfig)),
+		podExecutor:     podexec.NewPodCommandExecutor(clientConfig, kubeClient.CoreV1().RESTClient()),
+		preHooks:        preHooks,
+		drainWait:       drainWait,
+		drainUsesGrace:  drainUsesGrace,
+		forcePDB:        os.Getenv(ForcePDBViolationEnvVar) == "true",
+		workers:         workers,
+		maintenanceMode: maintenanceMode,
+	}, nil
+}
+
+// QuiesceDeployment annotates the deployment's pods with backup traceability
+// info, then scales the live deployment to targetReplicas via whatever
+// group/version the item was backed up from. If waitForTermination is set,
+// it waits for the deployment's excess pods to terminate before returning.
+func (q *Quiescer) QuiesceDeployment(ctx context.Context, item runtime.Unstructured, backup *v1.Backup, targetReplicas int64, waitForTermination bool) error {
+	namespace, _, err := unstructured.NestedString(item.UnstructuredContent(), "metadata", "namespace")
+	if err != nil {
+		return errors.Wrap(err, "error getting .metadata.namespace")
+	}
+	name, _, err := unstructured.NestedString(item.UnstructuredContent(), "metadata", "name")
+	if err != nil {
+		return errors.Wrap(err, "error getting .metadata.name")
+	}
+
+	if err := q.checkPodDisruptionBudgets(item, namespace, name, targetReplicas); err != nil {
+		return err
+	}
+
+	q.notifier.QuiesceStarting(backup.Name, namespace)
+	q.enterMaintenance(namespace, backup.Name)
+	q.markNamespace(namespace, backup.Name)
+
+	// Workloads that depend on this one go down first, in topological
+	// order, so e.g. consumers stop before their message broker does.
+	visited := map[string]bool{namespace + "/" + name: true}
+	if err := q.quiesceDependents(backup, namespace+"/"+name, visited); err != nil {
+		return err
+	}
+
+	if err := q.runPreHooks(item, namespace); err != nil {
+		return err
+	}
+
+	if err := q.annotatePods(item, backup, namespace); err != nil {
+		return err
+	}
+
+	ctx, span := q.tracer.StartSpan(ctx, "quiesce.discovery")
+	client, err := q.clientForItem(item, namespace)
+	span.End()
+	if err != nil {
+		return err
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:%q}},"spec":{"replicas":%d}}`,
+		QuiescedByAnnotation, backup.Name, targetReplicas,
+	))
+	scaledDownAt := time.Now()
+	ctx, span = q.tracer.StartSpan(ctx, "quiesce.scale")
+	span.SetAttribute("namespace", namespace)
+	span.SetAttribute("name", name)
+	err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		_, err := client.Patch(name, patch)
+		return err
+	})
+	span.End()
+	if err != nil {
+		return errors.Wrapf(err, "error scaling down live deployment %s/%s", namespace, name)
+	}
+
+	q.log.Infof("Scaled down live deployment %s/%s to %d replicas", namespace, name, targetReplicas)
+
+	if originalReplicas, found, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas"); err == nil && found {
+		apiVersion, _, _ := unstructured.NestedString(item.UnstructuredContent(), "apiVersion")
+		q.mu.Lock()
+		// An eagerly-quiesced dependent is already recorded, with its
+		// live replica count — the item's is post-scale-down.
+		if _, ok := q.preQuiesced[namespace+"/"+name]; !ok {
+			q.quiesced = append(q.quiesced, quiescedWorkload{
+				apiVersion:       apiVersion,
+				namespace:        namespace,
+				name:             name,
+				originalReplicas: originalReplicas,
+			})
+		}
+		q.mu.Unlock()
+	}
+
+	if wait := q.drainWaitFor(item); wait > 0 {
+		q.log.Infof("Waiting %s for deployment %s/%s to drain", wait, namespace, name)
+		time.Sleep(wait)
+	}
+
+	if waitForTermination {
+		_, span = q.tracer.StartSpan(ctx, "quiesce.wait")
+		err := q.waitForTermination(item, namespace, targetReplicas)
+		span.End()
+		if err != nil {
+			return err
+		}
+		if q.metrics != nil {
+			q.metrics.ObserveQuiesceDuration(namespace, time.Since(scaledDownAt))
+		}
+	}
+
+	return nil
+}
+
+// checkPodDisruptionBudgets refuses to scale the deployment below the
+// minAvailable of any PodDisruptionBudget selecting its pods, unless the
+// force override is set.
+func (q *Quiescer) checkPodDisruptionBudgets(item runtime.Unstructured, namespace, name string, targetReplicas int64) error {
+	podLabels, found, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "template", "metadata", "labels")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec.template.metadata.labels")
+	}
+	if !found {
+		return nil
+	}
+
+	replicas, _, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "replicas")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec.replicas")
+	}
+
+	pdbs, err := q.kubeClient.PolicyV1beta1().PodDisruptionBudgets(namespace).List(metav1.ListOptions{})
+	if err != nil {
+		return errors.Wrap(err, "error listing poddisruptionbudgets")
+	}
+
+	for i := range pdbs.Items {
+		pdb := &pdbs.Items[i]
+		if pdb.Spec.MinAvailable == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(podLabels)) {
+			continue
+		}
+
+		minAvailable, err := intstr.GetValueFromIntOrPercent(pdb.Spec.MinAvailable, int(replicas), true)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving minAvailable of poddisruptionbudget %s/%s", namespace, pdb.Name)
+		}
+
+		if targetReplicas < int64(minAvailable) {
+			if q.forcePDB {
+				q.log.Warnf("Scaling deployment %s/%s to %d replicas violates poddisruptionbudget %s (minAvailable %s); proceeding because %s is set",
+					namespace, name, targetReplicas, pdb.Name, pdb.Spec.MinAvailable.String(), ForcePDBViolationEnvVar)
+				continue
+			}
+
+			return errors.Errorf("scaling deployment %s/%s to %d replicas would violate poddisruptionbudget %s (minAvailable %s); set %s=true to override",
+				namespace, name, targetReplicas, pdb.Name, pdb.Spec.MinAvailable.String(), ForcePDBViolationEnvVar)
+		}
+	}
+
+	return nil
+}
+
+// drainWaitFor returns how long to wait for the workload's in-flight
+// requests to finish after the scale-down: either the configured fixed
+// duration, or the workload's own terminationGracePeriodSeconds.
+func (q *Quiescer) drainWaitFor(item runtime.Unstructured) time.Duration {
+	if !q.drainUsesGrace {
+		return q.drainWait
+	}
+
+	gracePeriod, found, err := unstructured.NestedInt64(item.UnstructuredContent(), "spec", "template", "spec", "terminationGracePeriodSeconds")
+	if err != nil || !found {
+		// The kubernetes default.
+		gracePeriod = 30
+	}
+
+	return time.Duration(gracePeriod) * time.Second
+}
+
+// waitForTermination polls the informer cache until at most targetReplicas
+// pods matching the deployment's selector remain, or the timeout elapses.
+func (q *Quiescer) waitForTermination(item runtime.Unstructured, namespace string, targetReplicas int64) error {
+	matchLabels, found, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "selector", "matchLabels")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec.selector.matchLabels")
+	}
+	if !found {
+		return nil
+	}
+
+	selector := labels.SelectorFromSet(labels.Set(matchLabels))
+
+	return wait.PollImmediate(terminationPollInterval, terminationPollTimeout, func() (bool, error) {
+		pods, err := q.podLister.Pods(namespace).List(selector)
+		if err != nil {
+			return false, errors.Wrap(err, "error listing deployment's pods")
+		}
+		return int64(len(pods)) <= targetReplicas, nil
+	})
+}
+
+// RollbackAll scales every workload this process quiesced back to its
+// original replica count and clears the quiesced-by annotation, so a
+// shutdown mid-backup doesn't leave workloads scaled down. Workloads come
+// back up in reverse quiesce order, so dependencies are restored before
+// their dependents.
+func (q *Quiescer) RollbackAll() error {
+	q.mu.Lock()
+	quiesced := q.quiesced
+	q.quiesced = nil
+	q.preQuiesced = nil
+	maintenanceNamespaces := q.maintenanceNamespaces
+	q.maintenanceNamespaces = nil
+	markedNamespaces := q.markedNamespaces
+	q.markedNamespaces = nil
+	q.mu.Unlock()
+
+	for namespace := range maintenanceNamespaces {
+		ClearMaintenance(q.log, q.kubeClient, namespace)
+	}
+	for namespace := range markedNamespaces {
+		ClearNamespaceMarker(q.log, q.kubeClient, namespace)
+	}
+
+	var errs []error
+	for i := len(quiesced) - 1; i >= 0; i-- {
+		workload := quiesced[i]
+
+		client, err := q.clientFor(workload.apiVersion, workload.namespace)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		patch := []byte(fmt.Sprintf(
+			`{"metadata":{"annotations":{%q:null}},"spec":{"replicas":%d}}`,
+			QuiescedByAnnotation, workload.originalReplicas,
+		))
+		err = retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+			_, err := client.Patch(workload.name, patch)
+			return err
+		})
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "error rolling back quiesce of deployment %s/%s", workload.namespace, workload.name))
+			continue
+		}
+
+		q.log.Infof("Rolled back quiesce of deployment %s/%s to %d replicas", workload.namespace, workload.name, workload.originalReplicas)
+	}
+
+	return kerrors.NewAggregate(errs)
+}
+
+// clientForItem returns a dynamic client for the item's apiVersion and
+// resource, resolved through the cached discovery helper.
+func (q *Quiescer) clientForItem(item runtime.Unstructured, namespace string) (arkclient.Dynamic, error) {
+	apiVersion, _, err := unstructured.NestedString(item.UnstructuredContent(), "apiVersion")
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting .apiVersion")
+	}
+
+	return q.clientFor(apiVersion, namespace)
+}
+
+// clientFor returns a dynamic client for the deployments resource of the
+// given apiVersion, resolved through the cached discovery helper.
+func (q *Quiescer) clientFor(apiVersion, namespace string) (arkclient.Dynamic, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing apiVersion %q", apiVersion)
+	}
+
+	gvr, apiResource, err := q.discoveryHelper.ResourceFor(gv.WithResource("deployments"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error resolving resource for %s", gv.WithResource("deployments"))
+	}
+
+	client, err := q.dynamicFactory.ClientForGroupVersionResource(gvr.GroupVersion(), apiResource, namespace)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting dynamic client for %s", gvr)
+	}
+
+	return client, nil
+}
+
+// runPreHooks executes the configured pre-freeze hook, if any, in each of
+// the deployment's pods before it's scaled down, mirroring Ark's backup
+// hook semantics. A hook with OnError=Fail aborts the quiesce; one with
+// OnError=Continue only logs.
+func (q *Quiescer) runPreHooks(item runtime.Unstructured, namespace string) error {
+	hook, ok := q.preHooks["deployments.apps"]
+	if !ok {
+		return nil
+	}
+
+	matchLabels, found, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "selector", "matchLabels")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec.selector.matchLabels")
+	}
+	if !found {
+		return nil
+	}
+
+	pods, err := q.podLister.Pods(namespace).List(labels.SelectorFromSet(labels.Set(matchLabels)))
+	if err != nil {
+		return errors.Wrap(err, "error listing deployment's pods")
+	}
+
+	tasks := make([]func() error, 0, len(pods))
+	for i := range pods {
+		pod := pods[i]
+		tasks = append(tasks, func() error {
+			podMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(pod)
+			if err != nil {
+				return errors.Wrapf(err, "error converting pod %s/%s to unstructured", namespace, pod.Name)
+			}
+
+			err = q.podExecutor.ExecutePodCommand(q.log, podMap, namespace, pod.Name, "pre-quiesce", hook.ExecHook())
+			if err != nil && hook.OnError == v1.HookErrorModeContinue {
+				q.log.WithError(err).Warnf("Pre-quiesce hook failed in pod %s/%s, continuing", namespace, pod.Name)
+				return nil
+			}
+			return errors.Wrapf(err, "error running pre-quiesce hook in pod %s/%s", namespace, pod.Name)
+		})
+	}
+
+	return q.runParallel(tasks)
+}
+
+// annotatePods applies the backup name and timestamp annotations to all pods
+// matching the deployment's selector. Pods come from the informer cache;
+// the patches run on the worker pool.
+func (q *Quiescer) annotatePods(item runtime.Unstructured, backup *v1.Backup, namespace string) error {
+	matchLabels, found, err := unstructured.NestedStringMap(item.UnstructuredContent(), "spec", "selector", "matchLabels")
+	if err != nil {
+		return errors.Wrap(err, "error getting .spec.selector.matchLabels")
+	}
+	if !found {
+		q.log.Info("deployment has no .spec.selector.matchLabels, not annotating pods")
+		return nil
+	}
+
+	pods, err := q.podLister.Pods(namespace).List(labels.SelectorFromSet(labels.Set(matchLabels)))
+	if err != nil {
+		return errors.Wrap(err, "error listing deployment's pods")
+	}
+
+	patch := []byte(fmt.Sprintf(
+		`{"metadata":{"annotations":{%q:%q,%q:%q}}}`,
+		podBackupNameAnnotation, backup.Name,
+		podBackupTimestampAnnotation, time.Now().UTC().Format(time.RFC3339),
+	))
+
+	tasks := make([]func() error, 0, len(pods))
+	for i := range pods {
+		pod := pods[i]
+		tasks = append(tasks, func() error {
+			// Concurrent controller updates shouldn't fail the whole
+			// backup item; retry conflicts with backoff.
+			err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+				_, err := q.kubeClient.CoreV1().Pods(namespace).Patch(pod.Name, types.MergePatchType, patch)
+				return err
+			})
+			if err != nil {
+				return errors.Wrapf(err, "error annotating pod %s/%s", namespace, pod.Name)
+			}
+			q.log.Infof("Annotated pod %s/%s with backup info", namespace, pod.Name)
+			return nil
+		})
+	}
+
+	return q.runParallel(tasks)
+}
+
+// runParallel runs tasks with at most q.workers running concurrently and
+// returns an aggregate of any errors.
+func (q *Quiescer) runParallel(tasks []func() error) error {
+	var (
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, q.workers)
+		mu   sync.Mutex
+		errs []error
+	)
+
+	for _, task := range tasks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(task func() error) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := task(); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(task)
+	}
+
+	wg.Wait()
+
+	return kerrors.NewAggregate(errs)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quiesce
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunParallelBoundsConcurrency(t *testing.T) {
+	const workers = 3
+
+	q := &Quiescer{workers: workers}
+
+	var (
+		mu      sync.Mutex
+		running int
+		peak    int
+
+		barrier = make(chan struct{})
+	)
+
+	tasks := make([]func() error, 10)
+	for i := range tasks {
+		tasks[i] = func() error {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			<-barrier
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- q.runParallel(tasks)
+	}()
+
+	close(barrier)
+	require.NoError(t, <-done)
+
+	assert.True(t, peak <= workers, "expected at most %d concurrent tasks, saw %d", workers, peak)
+}
+
+func TestRunParallelRunsAllTasks(t *testing.T) {
+	q := &Quiescer{workers: 2}
+
+	var count int64
+	tasks := make([]func() error, 25)
+	for i := range tasks {
+		tasks[i] = func() error {
+			atomic.AddInt64(&count, 1)
+			return nil
+		}
+	}
+
+	require.NoError(t, q.runParallel(tasks))
+	assert.Equal(t, int64(25), count)
+}
+
+func TestRunParallelAggregatesErrors(t *testing.T) {
+	q := &Quiescer{workers: 2}
+
+	tasks := []func() error{
+		func() error { return nil },
+		func() error { return errors.New("first") },
+		func() error { return errors.New("second") },
+	}
+
+	err := q.runParallel(tasks)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "first")
+	assert.Contains(t, err.Error(), "second")
+}
+
+func TestRunParallelNoTasks(t *testing.T) {
+	q := &Quiescer{workers: 1}
+	assert.NoError(t, q.runParallel(nil))
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package report maintains a ScaleActionReport custom resource per backup,
+// summarizing every item the plugin processed, the decision taken, and any
+// errors, so quiesce history is queryable with kubectl instead of by
+// parsing logs. The CRD is in examples/scaleactionreport-crd.yaml.
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/heptio/ark/pkg/apis/ark/v1"
+)
+
+// ReportEnvVar enables per-backup ScaleActionReport CRs when set to
+// "true".
+const ReportEnvVar = "ARK_SCALE_ACTION_REPORT"
+
+const (
+	reportAPIVersion = "scale-deployment.ark.heptio.com/v1"
+	reportResource   = "scaleactionreports"
+)
+
+// Entry is one processed item's outcome.
+type Entry struct {
+	Namespace string
+	Name      string
+	Decision  string
+	Reason    string
+	Error     string
+	Timestamp time.Time
+}
+
+// Reporter accumulates entries per backup and mirrors them onto a
+// ScaleActionReport CR in the backup's namespace, named after the backup.
+// Report updates are best-effort: a reporting failure is logged, never
+// surfaced as an item error.
+type Reporter struct {
+	log        logrus.FieldLogger
+	clientPool dynamic.ClientPool
+
+	mu sync.Mutex
+	// entries is keyed by backup namespace/name.
+	entries map[string][]Entry
+}
+
+// NewReporter returns a Reporter writing through the given client config.
+func NewReporter(log logrus.FieldLogger, clientConfig *rest.Config) *Reporter {
+	return &Reporter{
+		log:        log,
+		clientPool: dynamic.NewDynamicClientPool(clientConfig),
+		entries:    make(map[string][]Entry),
+	}
+}
+
+// Record appends the entry to the backup's report and pushes the updated
+// report to the cluster. Safe to call on a nil Reporter.
+func (r *Reporter) Record(backup *v1.Backup, entry Entry) {
+	if r == nil {
+		return
+	}
+
+	key := backup.Namespace + "/" + backup.Name
+
+	r.mu.Lock()
+	r.entries[key] = append(r.entries[key], entry)
+	entries := make([]Entry, len(r.entries[key]))
+	copy(entries, r.entries[key])
+	r.mu.Unlock()
+
+	if err := r.push(backup, entries); err != nil {
+		r.log.WithError(err).Warnf("Error updating scaleactionreport for backup %s", backup.Name)
+	}
+}
+
+// push writes the entries onto the backup's ScaleActionReport, creating it
+// on first use.
+func (r *Reporter) push(backup *v1.Backup, entries []Entry) error {
+	client, err := r.reportClient(backup.Namespace)
+	if err != nil {
+		return err
+	}
+
+	report, err := client.Get(backup.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		report = &unstructured.Unstructured{
+			Object: map[string]interface{}{
+				"apiVersion": reportAPIVersion,
+				"kind":       "ScaleActionReport",
+				"metadata": map[string]interface{}{
+					"namespace": backup.Namespace,
+					"name":      backup.Name,
+				},
+				"spec": map[string]interface{}{
+					"backup": backup.Name,
+				},
+			},
+		}
+		if report, err = client.Create(report); err != nil {
+			return errors.Wrap(err, "error creating scaleactionreport")
+		}
+	case err != nil:
+		return errors.Wrap(err, "error getting scaleactionreport")
+	}
+
+	items := make([]interface{}, 0, len(entries))
+	summary := map[string]interface{}{}
+	for _, entry := range entries {
+		item := map[string]interface{}{
+			"namespace": entry.Namespace,
+			"name":      entry.Name,
+			"decision":  entry.Decision,
+			"reason":    entry.Reason,
+			"timestamp": entry.Timestamp.UTC().Format(time.RFC3339),
+		}
+		if entry.Error != "" {
+			item["error"] = entry.Error
+		}
+
+		items = append(items, item)
+
+		count, _ := summary[entry.Decision].(int64)
+		summary[entry.Decision] = count + 1
+	}
+
+	return retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		if err := unstructured.SetNestedSlice(report.UnstructuredContent(), items, "status", "items"); err != nil {
+			return errors.Wrap(err, "error setting status.items")
+		}
+		if err := unstructured.SetNestedMap(report.UnstructuredContent(), summary, "status", "summary"); err != nil {
+			return errors.Wrap(err, "error setting status.summary")
+		}
+
+		_, updateErr := client.Update(report)
+		if apierrors.IsConflict(updateErr) {
+			if refreshed, err := client.Get(backup.Name, metav1.GetOptions{}); err == nil {
+				report = refreshed
+			}
+		}
+		return updateErr
+	})
+}
+
+func (r *Reporter) reportClient(namespace string) (dynamic.ResourceInterface, error) {
+	gv, err := schema.ParseGroupVersion(reportAPIVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing apiVersion %q", reportAPIVersion)
+	}
+
+	client, err := r.clientPool.ClientForGroupVersionKind(gv.WithKind(""))
+	if err != nil {
+		return nil, errors.Wrap(err, "error getting dynamic client for scaleactionreports")
+	}
+
+	apiResource := &metav1.APIResource{
+		Name:       reportResource,
+		Namespaced: true,
+	}
+
+	return client.Resource(apiResource, namespace), nil
+}
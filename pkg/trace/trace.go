@@ -0,0 +1,170 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package trace emits OpenCensus-style spans around the plugin's backup
+// item processing so slow backups can be traced end-to-end. Spans are
+// posted as JSON to a configurable collector endpoint; when no collector
+// is configured, all operations are no-ops.
+package trace
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CollectorEnvVar is the URL spans are POSTed to, one JSON-encoded span
+// per request body; tracing is disabled when unset.
+const CollectorEnvVar = "ARK_SCALE_TRACE_COLLECTOR"
+
+const (
+	sendQueueSize = 256
+	sendTimeout   = 5 * time.Second
+)
+
+// Span is a single timed operation within a trace. Spans within one
+// Execute call share a trace ID and are linked through parent span IDs.
+type Span struct {
+	TraceID    string            `json:"traceID"`
+	SpanID     string            `json:"spanID"`
+	ParentID   string            `json:"parentID,omitempty"`
+	Name       string            `json:"name"`
+	StartTime  time.Time         `json:"startTime"`
+	EndTime    time.Time         `json:"endTime"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+
+	tracer *Tracer
+}
+
+// Tracer sends finished spans to a collector. A nil *Tracer is valid and
+// discards all spans.
+type Tracer struct {
+	log      logrus.FieldLogger
+	endpoint string
+	spans    chan *Span
+}
+
+// NewTracerFromEnv returns a Tracer posting spans to the configured
+// collector, or nil if tracing is not configured.
+func NewTracerFromEnv(log logrus.FieldLogger) *Tracer {
+	endpoint := os.Getenv(CollectorEnvVar)
+	if endpoint == "" {
+		return nil
+	}
+
+	t := &Tracer{
+		log:      log,
+		endpoint: endpoint,
+		spans:    make(chan *Span, sendQueueSize),
+	}
+	go t.sender()
+
+	return t
+}
+
+type contextKey struct{}
+
+// StartSpan begins a span as a child of the span in ctx, if any, and
+// returns a context carrying the new span. Ending a span with no parent in
+// ctx starts a new trace.
+func (t *Tracer) StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		SpanID:    randomID(8),
+		Name:      name,
+		StartTime: time.Now().UTC(),
+		tracer:    t,
+	}
+
+	if parent, ok := ctx.Value(contextKey{}).(*Span); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = randomID(16)
+	}
+
+	return context.WithValue(ctx, contextKey{}, span), span
+}
+
+// SetAttribute records a key/value pair on the span. Safe to call on a nil
+// span.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+}
+
+// End finishes the span and queues it for export. Spans are dropped rather
+// than blocking item processing when the send queue is full. Safe to call
+// on a nil span.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.EndTime = time.Now().UTC()
+
+	select {
+	case s.tracer.spans <- s:
+	default:
+		s.tracer.log.Warnf("Trace send queue full, dropping span %s", s.Name)
+	}
+}
+
+// sender posts finished spans to the collector for the life of the
+// process.
+func (t *Tracer) sender() {
+	client := &http.Client{Timeout: sendTimeout}
+
+	for span := range t.spans {
+		body, err := json.Marshal(span)
+		if err != nil {
+			t.log.WithError(err).Warnf("Error encoding span %s", span.Name)
+			continue
+		}
+
+		res, err := client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.log.WithError(err).Warnf("Error sending span %s to collector", span.Name)
+			continue
+		}
+		res.Body.Close()
+	}
+}
+
+// randomID returns n random bytes hex-encoded, matching the OpenCensus
+// trace and span ID formats for n=16 and n=8.
+func randomID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"bytes"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RBACManifests generates the ClusterRole and ClusterRoleBinding YAML the
+// plugin needs for live quiesce, bound to the given service account. The
+// rules come from the same capability table CheckRBAC verifies, so the
+// manifests can't drift from what the code actually does.
+func RBACManifests(serviceAccountNamespace, serviceAccountName string) ([]byte, error) {
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRole",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "ark-scale-deployment",
+		},
+		Rules: policyRules(),
+	}
+
+	clusterRoleBinding := &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: "rbac.authorization.k8s.io/v1",
+			Kind:       "ClusterRoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "ark-scale-deployment",
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Namespace: serviceAccountNamespace,
+				Name:      serviceAccountName,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     "ark-scale-deployment",
+		},
+	}
+
+	var buf bytes.Buffer
+	for _, obj := range []interface{}{clusterRole, clusterRoleBinding} {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, errors.Wrap(err, "error marshaling RBAC manifest")
+		}
+		buf.WriteString("---\n")
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// policyRules converts the capability table into PolicyRules, one per
+// group/resource with all of its verbs.
+func policyRules() []rbacv1.PolicyRule {
+	type groupResource struct {
+		group    string
+		resource string
+	}
+
+	var (
+		order []groupResource
+		verbs = make(map[groupResource][]string)
+	)
+
+	for _, check := range liveQuiesceChecks {
+		gr := groupResource{group: check.group, resource: check.resource}
+		if _, seen := verbs[gr]; !seen {
+			order = append(order, gr)
+		}
+		verbs[gr] = append(verbs[gr], check.verb)
+	}
+
+	rules := make([]rbacv1.PolicyRule, 0, len(order))
+	for _, gr := range order {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups: []string{gr.group},
+			Resources: []string{gr.resource},
+			Verbs:     verbs[gr],
+		})
+	}
+
+	return rules
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package validation
+
+import (
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/skriss/ark-scale-deployment/pkg/config"
+	"github.com/skriss/ark-scale-deployment/pkg/mutation"
+)
+
+// CheckConfig validates every piece of the plugin's environment
+// configuration that can be parsed without a cluster: selectors, quiesce
+// profiles, persistence mode, error policies, filter expressions, hooks,
+// drain waits, quiesce windows, namespace replica defaults, and mutation
+// rules. It returns one error per problem found, so an operator sees all
+// of them at once.
+func CheckConfig() []error {
+	var errs []error
+
+	if _, err := config.BackupSelectorFromEnv(); err != nil {
+		errs = append(errs, err)
+	}
+
+	profiles, err := config.LoadProfiles()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	for name, profile := range profiles {
+		if profile.TargetReplicas < 0 {
+			errs = append(errs, errors.Errorf("profile %q has negative targetReplicas %d", name, profile.TargetReplicas))
+		}
+	}
+
+	if _, err := config.LoadPersistenceMode(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.LoadErrorPolicies(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.LoadFilterExpression(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.LoadTargetReplicasExpression(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.LoadPreHooks(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := config.LoadDrainWait(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := config.LoadQuiesceWindows(); err != nil {
+		errs = append(errs, err)
+	}
+
+	namespaceDefaults, err := config.LoadNamespaceReplicaDefaults()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for namespace, replicas := range namespaceDefaults {
+		if replicas < 0 {
+			errs = append(errs, errors.Errorf("namespace %q has negative replica default %d", namespace, replicas))
+		}
+	}
+
+	discard := logrus.New()
+	discard.Out = ioutil.Discard
+	if _, err := mutation.NewEngineFromEnv(discard); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+// CheckNamespaces verifies that every namespace named in the AppliesTo
+// selector exists. Live quiesce against a typo'd namespace would otherwise
+// just quietly match nothing.
+func CheckNamespaces(kubeClient kubernetes.Interface) []error {
+	selector, err := config.BackupSelectorFromEnv()
+	if err != nil {
+		// CheckConfig reports the parse failure.
+		return nil
+	}
+
+	var errs []error
+	for _, namespace := range selector.IncludedNamespaces {
+		if _, err := kubeClient.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{}); err != nil {
+			errs = append(errs, errors.Wrapf(err, "namespace %q in the AppliesTo selector doesn't exist", namespace))
+		}
+	}
+
+	return errs
+}
+
+// rbacCheck is one permission live quiesce needs.
+type rbacCheck struct {
+	verb     string
+	group    string
+	resource string
+}
+
+// liveQuiesceChecks are the permissions the plugin needs to scale live
+// workloads down and annotate their pods.
+var liveQuiesceChecks = []rbacCheck{
+	{verb: "get", group: "apps", resource: "deployments"},
+	{verb: "patch", group: "apps", resource: "deployments"},
+	{verb: "list", resource: "pods"},
+	{verb: "watch", resource: "pods"},
+	{verb: "patch", resource: "pods"},
+}
+
+// CheckRBAC verifies via SelfSubjectAccessReviews that the plugin's service
+// account has the permissions live quiesce needs. It returns one error per
+// missing permission.
+func CheckRBAC(kubeClient kubernetes.Interface) []error {
+	var errs []error
+
+	for _, check := range liveQuiesceChecks {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Verb:     check.verb,
+					Group:    check.group,
+					Resource: check.resource,
+				},
+			},
+		}
+
+		result, err := kubeClient.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "error checking permission to %s %s", check.verb, check.resource))
+			continue
+		}
+
+		if !result.Status.Allowed {
+			errs = append(errs, errors.Errorf("missing permission to %s %s.%s; grant it to the plugin's service account or disable live quiesce", check.verb, check.resource, check.group))
+		}
+	}
+
+	return errs
+}
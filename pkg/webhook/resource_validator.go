@@ -0,0 +1,254 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+
+	arkv1 "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	"github.com/heptio/ark/pkg/util/collections"
+)
+
+// ResourceValidator is a validating admission webhook for Ark Backup and
+// Restore objects. It runs spec checks at creation time - contradictory
+// include/exclude lists, resources the cluster doesn't have, snapshots
+// requested with no persistent volume provider configured - so users get
+// immediate feedback instead of finding the object in FailedValidation
+// after the server processes it.
+type ResourceValidator struct {
+	log             logrus.FieldLogger
+	addr            string
+	certFile        string
+	keyFile         string
+	discoveryClient discovery.DiscoveryInterface
+	arkClient       arkclientset.Interface
+	arkNamespace    string
+}
+
+// NewResourceValidator returns a ResourceValidator that will listen on addr
+// with the given TLS keypair. The discovery client is used to check that
+// included/excluded resources exist in the cluster, and the Ark client is
+// used to check whether a persistent volume provider is configured.
+func NewResourceValidator(
+	log logrus.FieldLogger,
+	addr, certFile, keyFile string,
+	discoveryClient discovery.DiscoveryInterface,
+	arkClient arkclientset.Interface,
+	arkNamespace string,
+) *ResourceValidator {
+	return &ResourceValidator{
+		log:             log,
+		addr:            addr,
+		certFile:        certFile,
+		keyFile:         keyFile,
+		discoveryClient: discoveryClient,
+		arkClient:       arkClient,
+		arkNamespace:    arkNamespace,
+	}
+}
+
+// Run serves the webhook until the process exits.
+func (s *ResourceValidator) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", s.serveValidate)
+
+	s.log.Infof("Listening on %s", s.addr)
+	return http.ListenAndServeTLS(s.addr, s.certFile, s.keyFile, mux)
+}
+
+func (s *ResourceValidator) serveValidate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := s.admit(review.Request)
+	if review.Request != nil {
+		response.UID = review.Request.UID
+	}
+
+	review.Response = response
+
+	out, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// admit validates the spec of a Backup or Restore being created; everything
+// else is allowed.
+func (s *ResourceValidator) admit(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	allowed := &admissionv1beta1.AdmissionResponse{Allowed: true}
+
+	if request == nil || request.Operation != admissionv1beta1.Create {
+		return allowed
+	}
+
+	var errs []error
+	switch request.Kind.Kind {
+	case "Backup":
+		backup := &arkv1.Backup{}
+		if err := json.Unmarshal(request.Object.Raw, backup); err != nil {
+			s.log.WithError(err).Warn("Error decoding backup, allowing")
+			return allowed
+		}
+		errs = s.validateBackup(backup)
+	case "Restore":
+		restore := &arkv1.Restore{}
+		if err := json.Unmarshal(request.Object.Raw, restore); err != nil {
+			s.log.WithError(err).Warn("Error decoding restore, allowing")
+			return allowed
+		}
+		errs = s.validateRestore(restore)
+	default:
+		return allowed
+	}
+
+	if len(errs) == 0 {
+		return allowed
+	}
+
+	s.log.Infof("Denying %s %s/%s: %v", request.Kind.Kind, request.Namespace, request.Name, kerrors.NewAggregate(errs))
+
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Reason:  metav1.StatusReasonInvalid,
+			Message: kerrors.NewAggregate(errs).Error(),
+		},
+	}
+}
+
+func (s *ResourceValidator) validateBackup(backup *arkv1.Backup) []error {
+	var errs []error
+
+	errs = append(errs, collections.ValidateIncludesExcludes(backup.Spec.IncludedResources, backup.Spec.ExcludedResources)...)
+	errs = append(errs, collections.ValidateIncludesExcludes(backup.Spec.IncludedNamespaces, backup.Spec.ExcludedNamespaces)...)
+
+	errs = append(errs, s.checkResourcesExist(backup.Spec.IncludedResources)...)
+	errs = append(errs, s.checkResourcesExist(backup.Spec.ExcludedResources)...)
+
+	if backup.Spec.SnapshotVolumes != nil && *backup.Spec.SnapshotVolumes && !s.snapshotsEnabled() {
+		errs = append(errs, errors.New("volume snapshots are requested but no persistent volume provider is configured"))
+	}
+
+	return errs
+}
+
+func (s *ResourceValidator) validateRestore(restore *arkv1.Restore) []error {
+	var errs []error
+
+	if restore.Spec.BackupName == "" {
+		errs = append(errs, errors.New("BackupName must be non-empty"))
+	}
+
+	errs = append(errs, collections.ValidateIncludesExcludes(restore.Spec.IncludedResources, restore.Spec.ExcludedResources)...)
+	errs = append(errs, collections.ValidateIncludesExcludes(restore.Spec.IncludedNamespaces, restore.Spec.ExcludedNamespaces)...)
+
+	errs = append(errs, s.checkResourcesExist(restore.Spec.IncludedResources)...)
+	errs = append(errs, s.checkResourcesExist(restore.Spec.ExcludedResources)...)
+
+	if restore.Spec.RestorePVs != nil && *restore.Spec.RestorePVs && !s.snapshotsEnabled() {
+		errs = append(errs, errors.New("PV restores are requested but no persistent volume provider is configured"))
+	}
+
+	return errs
+}
+
+// checkResourcesExist verifies via discovery that each named resource
+// exists in the cluster, accepting both bare ("pods") and fully-qualified
+// ("deployments.apps") names. Discovery failures are logged and skipped
+// rather than blocking admission.
+func (s *ResourceValidator) checkResourcesExist(resources []string) []error {
+	var toCheck []string
+	for _, resource := range resources {
+		if resource != "*" {
+			toCheck = append(toCheck, resource)
+		}
+	}
+	if len(toCheck) == 0 {
+		return nil
+	}
+
+	resourceLists, err := s.discoveryClient.ServerResources()
+	if err != nil {
+		s.log.WithError(err).Warn("Error getting server resources, skipping resource existence checks")
+		return nil
+	}
+
+	known := make(map[string]struct{})
+	for _, resourceList := range resourceLists {
+		gv, err := schema.ParseGroupVersion(resourceList.GroupVersion)
+		if err != nil {
+			continue
+		}
+
+		for _, resource := range resourceList.APIResources {
+			known[resource.Name] = struct{}{}
+			if gv.Group != "" {
+				known[resource.Name+"."+gv.Group] = struct{}{}
+			}
+		}
+	}
+
+	var errs []error
+	for _, resource := range toCheck {
+		if _, ok := known[strings.ToLower(resource)]; !ok {
+			errs = append(errs, errors.Errorf("resource %q does not exist in the cluster", resource))
+		}
+	}
+
+	return errs
+}
+
+// snapshotsEnabled reports whether the Ark server's Config has a persistent
+// volume provider. Errors reading the config are logged and treated as
+// "enabled" so admission isn't blocked on them.
+func (s *ResourceValidator) snapshotsEnabled() bool {
+	config, err := s.arkClient.ArkV1().Configs(s.arkNamespace).Get("default", metav1.GetOptions{})
+	if err != nil {
+		s.log.WithError(err).Warn("Error getting Ark config, skipping snapshot provider check")
+		return true
+	}
+
+	return config.PersistentVolumeProvider != nil
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	discoveryfake "k8s.io/client-go/discovery/fake"
+	core "k8s.io/client-go/testing"
+
+	arkv1 "github.com/heptio/ark/pkg/apis/ark/v1"
+	"github.com/heptio/ark/pkg/generated/clientset/versioned/fake"
+	arktest "github.com/heptio/ark/pkg/util/test"
+)
+
+func newTestValidator(t *testing.T, pvProvider bool) *ResourceValidator {
+	config := &arkv1.Config{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: arkv1.DefaultNamespace,
+			Name:      "default",
+		},
+	}
+	if pvProvider {
+		config.PersistentVolumeProvider = &arkv1.CloudProviderConfig{Name: "aws"}
+	}
+
+	discoveryClient := &discoveryfake.FakeDiscovery{
+		Fake: &core.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{{Name: "pods"}, {Name: "secrets"}},
+				},
+				{
+					GroupVersion: "apps/v1",
+					APIResources: []metav1.APIResource{{Name: "deployments"}},
+				},
+			},
+		},
+	}
+
+	return NewResourceValidator(
+		arktest.NewLogger(),
+		"",
+		"",
+		"",
+		discoveryClient,
+		fake.NewSimpleClientset(config),
+		arkv1.DefaultNamespace,
+	)
+}
+
+func createRequest(t *testing.T, kind string, obj interface{}) *admissionv1beta1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+
+	return &admissionv1beta1.AdmissionRequest{
+		UID:       types.UID("uid"),
+		Kind:      metav1.GroupVersionKind{Group: "ark.heptio.com", Version: "v1", Kind: kind},
+		Operation: admissionv1beta1.Create,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	tests := []struct {
+		name            string
+		request         *admissionv1beta1.AdmissionRequest
+		pvProvider      bool
+		expectedAllowed bool
+	}{
+		{
+			name:            "nil request is allowed",
+			request:         nil,
+			expectedAllowed: true,
+		},
+		{
+			name: "non-create operations are allowed",
+			request: &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Kind: "Backup"},
+				Operation: admissionv1beta1.Update,
+			},
+			expectedAllowed: true,
+		},
+		{
+			name: "unhandled kinds are allowed",
+			request: &admissionv1beta1.AdmissionRequest{
+				Kind:      metav1.GroupVersionKind{Kind: "Schedule"},
+				Operation: admissionv1beta1.Create,
+			},
+			expectedAllowed: true,
+		},
+		{
+			name:            "valid backup is allowed",
+			request:         nil, // filled in below
+			expectedAllowed: true,
+		},
+	}
+
+	validBackup := arktest.NewTestBackup().WithName("backup-1").Backup
+	validBackup.Spec.IncludedResources = []string{"deployments.apps", "pods"}
+	tests[3].request = createRequest(t, "Backup", validBackup)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestValidator(t, test.pvProvider)
+
+			response := s.admit(test.request)
+			assert.Equal(t, test.expectedAllowed, response.Allowed)
+		})
+	}
+}
+
+func TestAdmitDeniesInvalidBackups(t *testing.T) {
+	trueVal := true
+
+	tests := []struct {
+		name       string
+		spec       arkv1.BackupSpec
+		pvProvider bool
+	}{
+		{
+			name: "resource in both includes and excludes",
+			spec: arkv1.BackupSpec{
+				IncludedResources: []string{"pods"},
+				ExcludedResources: []string{"pods"},
+			},
+		},
+		{
+			name: "namespace in both includes and excludes",
+			spec: arkv1.BackupSpec{
+				IncludedNamespaces: []string{"ns-1"},
+				ExcludedNamespaces: []string{"ns-1"},
+			},
+		},
+		{
+			name: "included resource the cluster doesn't have",
+			spec: arkv1.BackupSpec{
+				IncludedResources: []string{"widgets.example.com"},
+			},
+		},
+		{
+			name: "snapshots requested with no PV provider",
+			spec: arkv1.BackupSpec{
+				SnapshotVolumes: &trueVal,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newTestValidator(t, test.pvProvider)
+
+			backup := arktest.NewTestBackup().WithName("backup-1").Backup
+			backup.Spec = test.spec
+
+			response := s.admit(createRequest(t, "Backup", backup))
+			require.NotNil(t, response.Result)
+			assert.False(t, response.Allowed)
+			assert.Equal(t, metav1.StatusReasonInvalid, response.Result.Reason)
+		})
+	}
+}
+
+func TestAdmitBackupSnapshotsWithProviderConfigured(t *testing.T) {
+	trueVal := true
+	s := newTestValidator(t, true)
+
+	backup := arktest.NewTestBackup().WithName("backup-1").Backup
+	backup.Spec.SnapshotVolumes = &trueVal
+
+	response := s.admit(createRequest(t, "Backup", backup))
+	assert.True(t, response.Allowed)
+}
+
+func TestAdmitRestores(t *testing.T) {
+	s := newTestValidator(t, false)
+
+	restore := &arkv1.Restore{
+		ObjectMeta: metav1.ObjectMeta{Name: "restore-1"},
+		Spec:       arkv1.RestoreSpec{BackupName: "backup-1"},
+	}
+	response := s.admit(createRequest(t, "Restore", restore))
+	assert.True(t, response.Allowed)
+
+	restore.Spec.BackupName = ""
+	response = s.admit(createRequest(t, "Restore", restore))
+	assert.False(t, response.Allowed)
+}
+
+func TestServeValidate(t *testing.T) {
+	s := newTestValidator(t, false)
+
+	backup := arktest.NewTestBackup().WithName("backup-1").Backup
+	review := &admissionv1beta1.AdmissionReview{
+		Request: createRequest(t, "Backup", backup),
+	}
+
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	s.serveValidate(recorder, httptest.NewRequest(http.MethodPost, "/validate", bytes.NewReader(body)))
+
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var result admissionv1beta1.AdmissionReview
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	require.NotNil(t, result.Response)
+	assert.True(t, result.Response.Allowed)
+	assert.Equal(t, review.Request.UID, result.Response.UID)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/skriss/ark-scale-deployment/pkg/quiesce"
+)
+
+// Server is a validating admission webhook that rejects replica increases
+// on workloads currently quiesced by an in-progress backup, so HPAs,
+// operators, and humans can't undo the quiesce mid-snapshot. A workload is
+// considered quiesced while it carries the quiesced-by annotation; removing
+// the annotation in the same update is allowed, which is how the restore
+// side releases the guard.
+type Server struct {
+	log      logrus.FieldLogger
+	addr     string
+	certFile string
+	keyFile  string
+}
+
+// NewServer returns a webhook Server that will listen on addr with the
+// given TLS keypair.
+func NewServer(log logrus.FieldLogger, addr, certFile, keyFile string) *Server {
+	return &Server{
+		log:      log,
+		addr:     addr,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+}
+
+// Run serves the webhook until the process exits.
+func (s *Server) Run() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", s.serveValidate)
+
+	s.log.Infof("Listening on %s", s.addr)
+	return http.ListenAndServeTLS(s.addr, s.certFile, s.keyFile, mux)
+}
+
+func (s *Server) serveValidate(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error reading request body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1beta1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, fmt.Sprintf("error decoding admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response := s.admit(review.Request)
+	if review.Request != nil {
+		response.UID = review.Request.UID
+	}
+
+	review.Response = response
+
+	out, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error encoding admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// admit allows everything except a replica increase on a workload that
+// still carries the quiesced-by annotation.
+func (s *Server) admit(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	allowed := &admissionv1beta1.AdmissionResponse{Allowed: true}
+
+	if request == nil || request.Operation != admissionv1beta1.Update {
+		return allowed
+	}
+
+	oldObj, err := decodeObject(request.OldObject.Raw)
+	if err != nil {
+		s.log.WithError(err).Warn("Error decoding old object, allowing")
+		return allowed
+	}
+	newObj, err := decodeObject(request.Object.Raw)
+	if err != nil {
+		s.log.WithError(err).Warn("Error decoding new object, allowing")
+		return allowed
+	}
+
+	backupName, quiesced := oldObj.GetAnnotations()[quiesce.QuiescedByAnnotation]
+	if !quiesced {
+		return allowed
+	}
+
+	// Removing the annotation releases the guard.
+	if _, stillQuiesced := newObj.GetAnnotations()[quiesce.QuiescedByAnnotation]; !stillQuiesced {
+		return allowed
+	}
+
+	oldReplicas, _, err := unstructured.NestedInt64(oldObj.UnstructuredContent(), "spec", "replicas")
+	if err != nil {
+		s.log.WithError(err).Warn("Error reading old .spec.replicas, allowing")
+		return allowed
+	}
+	newReplicas, found, err := unstructured.NestedInt64(newObj.UnstructuredContent(), "spec", "replicas")
+	if err != nil || !found {
+		return allowed
+	}
+
+	if newReplicas <= oldReplicas {
+		return allowed
+	}
+
+	s.log.Infof("Denying scale-up of %s %s/%s from %d to %d replicas (quiesced by backup %s)",
+		request.Kind.Kind, request.Namespace, request.Name, oldReplicas, newReplicas, backupName)
+
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Reason: metav1.StatusReasonForbidden,
+			Message: fmt.Sprintf(
+				"replicas cannot be increased while the workload is quiesced by in-progress backup %q; remove the %s annotation to override",
+				backupName, quiesce.QuiescedByAnnotation,
+			),
+		},
+	}
+}
+
+func decodeObject(raw []byte) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
@@ -0,0 +1,291 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command e2e runs the plugin's end-to-end suite against a real cluster
+// (typically kind, via hack/e2e.sh): it creates fixture workloads, runs a
+// backup and restore through an Ark server with the plugin registered, and
+// asserts that replica counts round-trip. Changes have passed unit-level
+// checks but broken against a real apiserver before; this catches those.
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	arkv1 "github.com/heptio/ark/pkg/apis/ark/v1"
+	arkclientset "github.com/heptio/ark/pkg/generated/clientset/versioned"
+	"github.com/heptio/ark/pkg/plugin"
+)
+
+const (
+	fixtureDeploymentReplicas  = 3
+	fixtureStatefulSetReplicas = 2
+
+	pollInterval = 5 * time.Second
+)
+
+func main() {
+	var (
+		kubeconfig   = pflag.String("kubeconfig", os.Getenv("KUBECONFIG"), "path to the kubeconfig for the test cluster")
+		namespace    = pflag.String("namespace", "ark-scale-e2e", "namespace to create fixture workloads in")
+		arkNamespace = pflag.String("ark-namespace", arkv1.DefaultNamespace, "namespace the Ark server runs in")
+		timeout      = pflag.Duration("timeout", 10*time.Minute, "per-phase timeout")
+	)
+	pflag.Parse()
+
+	log := plugin.NewLogger()
+
+	clientConfig, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to build client config")
+	}
+
+	kubeClient, err := kubernetes.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create kubernetes client")
+	}
+	arkClient, err := arkclientset.NewForConfig(clientConfig)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to create ark client")
+	}
+
+	run := &e2eRun{
+		kubeClient:   kubeClient,
+		arkClient:    arkClient,
+		namespace:    *namespace,
+		arkNamespace: *arkNamespace,
+		timeout:      *timeout,
+		name:         fmt.Sprintf("scale-e2e-%d", time.Now().Unix()),
+	}
+
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"create fixtures", run.createFixtures},
+		{"run backup", run.runBackup},
+		{"delete fixtures", run.deleteFixtures},
+		{"run restore", run.runRestore},
+		{"verify round-trip", run.verifyRoundTrip},
+	}
+
+	for _, step := range steps {
+		log.Infof("Step: %s", step.name)
+		if err := step.fn(); err != nil {
+			log.WithError(err).Fatalf("Step %q failed", step.name)
+		}
+	}
+
+	log.Info("E2E suite passed")
+}
+
+type e2eRun struct {
+	kubeClient   kubernetes.Interface
+	arkClient    arkclientset.Interface
+	namespace    string
+	arkNamespace string
+	timeout      time.Duration
+	name         string
+}
+
+// createFixtures creates the test namespace with a Deployment and a
+// StatefulSet at known replica counts, and waits for them to be ready.
+func (r *e2eRun) createFixtures() error {
+	_, err := r.kubeClient.CoreV1().Namespaces().Create(&corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: r.namespace},
+	})
+	if err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	deployment := fixtureDeployment(r.namespace)
+	if _, err := r.kubeClient.AppsV1().Deployments(r.namespace).Create(deployment); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	statefulSet := fixtureStatefulSet(r.namespace)
+	if _, err := r.kubeClient.AppsV1().StatefulSets(r.namespace).Create(statefulSet); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	return wait.PollImmediate(pollInterval, r.timeout, func() (bool, error) {
+		d, err := r.kubeClient.AppsV1().Deployments(r.namespace).Get(deployment.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		s, err := r.kubeClient.AppsV1().StatefulSets(r.namespace).Get(statefulSet.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return d.Status.ReadyReplicas == fixtureDeploymentReplicas && s.Status.ReadyReplicas == fixtureStatefulSetReplicas, nil
+	})
+}
+
+// runBackup creates a Backup of the fixture namespace and waits for it to
+// complete.
+func (r *e2eRun) runBackup() error {
+	backup := &arkv1.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: r.arkNamespace,
+			Name:      r.name,
+		},
+		Spec: arkv1.BackupSpec{
+			IncludedNamespaces: []string{r.namespace},
+		},
+	}
+	if _, err := r.arkClient.ArkV1().Backups(r.arkNamespace).Create(backup); err != nil {
+		return err
+	}
+
+	return wait.PollImmediate(pollInterval, r.timeout, func() (bool, error) {
+		current, err := r.arkClient.ArkV1().Backups(r.arkNamespace).Get(r.name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		switch current.Status.Phase {
+		case arkv1.BackupPhaseCompleted:
+			return true, nil
+		case arkv1.BackupPhaseFailed, arkv1.BackupPhaseFailedValidation:
+			return false, fmt.Errorf("backup entered phase %s", current.Status.Phase)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// deleteFixtures deletes the fixture namespace and waits for it to be
+// gone, simulating the disaster being recovered from.
+func (r *e2eRun) deleteFixtures() error {
+	if err := r.kubeClient.CoreV1().Namespaces().Delete(r.namespace, nil); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+
+	return wait.PollImmediate(pollInterval, r.timeout, func() (bool, error) {
+		_, err := r.kubeClient.CoreV1().Namespaces().Get(r.namespace, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+}
+
+// runRestore restores the backup and waits for it to complete.
+func (r *e2eRun) runRestore() error {
+	restore := &arkv1.Restore{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: r.arkNamespace,
+			Name:      r.name,
+		},
+		Spec: arkv1.RestoreSpec{
+			BackupName: r.name,
+		},
+	}
+	if _, err := r.arkClient.ArkV1().Restores(r.arkNamespace).Create(restore); err != nil {
+		return err
+	}
+
+	return wait.PollImmediate(pollInterval, r.timeout, func() (bool, error) {
+		current, err := r.arkClient.ArkV1().Restores(r.arkNamespace).Get(r.name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		switch current.Status.Phase {
+		case arkv1.RestorePhaseCompleted:
+			return true, nil
+		case arkv1.RestorePhaseFailedValidation:
+			return false, fmt.Errorf("restore entered phase %s", current.Status.Phase)
+		default:
+			return false, nil
+		}
+	})
+}
+
+// verifyRoundTrip asserts the restored workloads return to their original
+// replica counts, either directly or via the scale-restore controller.
+func (r *e2eRun) verifyRoundTrip() error {
+	return wait.PollImmediate(pollInterval, r.timeout, func() (bool, error) {
+		d, err := r.kubeClient.AppsV1().Deployments(r.namespace).Get("e2e-deployment", metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		s, err := r.kubeClient.AppsV1().StatefulSets(r.namespace).Get("e2e-statefulset", metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+
+		return d.Spec.Replicas != nil && *d.Spec.Replicas == fixtureDeploymentReplicas &&
+			s.Spec.Replicas != nil && *s.Spec.Replicas == fixtureStatefulSetReplicas, nil
+	})
+}
+
+func fixtureDeployment(namespace string) *appsv1.Deployment {
+	replicas := int32(fixtureDeploymentReplicas)
+	labels := map[string]string{"app": "e2e-deployment"}
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "e2e-deployment",
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: fixturePodTemplate(labels),
+		},
+	}
+}
+
+func fixtureStatefulSet(namespace string) *appsv1.StatefulSet {
+	replicas := int32(fixtureStatefulSetReplicas)
+	labels := map[string]string{"app": "e2e-statefulset"}
+
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      "e2e-statefulset",
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:    &replicas,
+			ServiceName: "e2e-statefulset",
+			Selector:    &metav1.LabelSelector{MatchLabels: labels},
+			Template:    fixturePodTemplate(labels),
+		},
+	}
+}
+
+func fixturePodTemplate(labels map[string]string) corev1.PodTemplateSpec {
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: labels},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:  "pause",
+					Image: "gcr.io/google-containers/pause:3.0",
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command goldengen generates unstructured workload fixtures — multiple
+// API versions, odd fields, missing replicas — and golden files recording
+// what the mutation engine does to each. Run with -verify to recompute the
+// goldens and fail on drift, locking mutation behavior down across
+// refactors.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/heptio/ark/pkg/plugin"
+
+	"github.com/skriss/ark-scale-deployment/pkg/mutation"
+)
+
+// fixtures are deliberately awkward: old API versions, absent replicas,
+// unknown fields, and numeric edge cases that have tripped the actions up
+// before.
+var fixtures = map[string]map[string]interface{}{
+	"deployment-apps-v1": {
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "frontend"},
+		"spec":       map[string]interface{}{"replicas": int64(3)},
+	},
+	"deployment-apps-v1beta2": {
+		"apiVersion": "apps/v1beta2",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "frontend-old"},
+		"spec":       map[string]interface{}{"replicas": int64(2)},
+	},
+	"deployment-extensions-v1beta1": {
+		"apiVersion": "extensions/v1beta1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "legacy", "name": "ancient"},
+		"spec":       map[string]interface{}{"replicas": int64(1)},
+	},
+	"deployment-missing-replicas": {
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "no-replicas"},
+		"spec":       map[string]interface{}{},
+	},
+	"deployment-weird-fields": {
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata": map[string]interface{}{
+			"namespace":   "web",
+			"name":        "weird",
+			"annotations": map[string]interface{}{"example.com/unknown": "value"},
+		},
+		"spec": map[string]interface{}{
+			"replicas":      float64(4),
+			"unknownField":  map[string]interface{}{"nested": true},
+			"paused":        false,
+			"minReadySecs":  int64(0),
+			"emptySelector": map[string]interface{}{},
+		},
+	},
+	"statefulset-apps-v1": {
+		"apiVersion": "apps/v1",
+		"kind":       "StatefulSet",
+		"metadata":   map[string]interface{}{"namespace": "db", "name": "postgres"},
+		"spec":       map[string]interface{}{"replicas": int64(3), "serviceName": "postgres"},
+	},
+	"hpa-autoscaling-v1": {
+		"apiVersion": "autoscaling/v1",
+		"kind":       "HorizontalPodAutoscaler",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "frontend"},
+		"spec": map[string]interface{}{
+			"minReplicas": int64(2),
+			"maxReplicas": int64(10),
+			"targetCPUUtilizationPercentage": int64(80),
+		},
+	},
+	"hpa-autoscaling-v2beta1": {
+		"apiVersion": "autoscaling/v2beta1",
+		"kind":       "HorizontalPodAutoscaler",
+		"metadata":   map[string]interface{}{"namespace": "web", "name": "frontend-v2"},
+		"spec": map[string]interface{}{
+			"minReplicas": int64(1),
+			"maxReplicas": int64(5),
+			"metrics":     []interface{}{map[string]interface{}{"type": "Resource"}},
+		},
+	},
+}
+
+func main() {
+	var (
+		outputDir = pflag.String("output-dir", "test/fixtures", "directory to write fixtures and goldens to")
+		verify    = pflag.Bool("verify", false, "recompute goldens and fail on drift instead of writing")
+	)
+	pflag.Parse()
+
+	log := plugin.NewLogger()
+
+	engine, err := mutation.NewEngineFromEnv(log)
+	if err != nil {
+		log.WithError(err).Fatal("Invalid mutation rules")
+	}
+
+	if !*verify {
+		if err := os.MkdirAll(*outputDir, 0755); err != nil {
+			log.WithError(err).Fatal("Unable to create output directory")
+		}
+	}
+
+	drift := false
+	for name, fixture := range fixtures {
+		fixtureJSON, goldenJSON, err := render(engine, fixture)
+		if err != nil {
+			log.WithError(err).Fatalf("Error rendering fixture %s", name)
+		}
+
+		fixturePath := filepath.Join(*outputDir, name+".json")
+		goldenPath := filepath.Join(*outputDir, name+".golden.json")
+
+		if *verify {
+			for path, want := range map[string][]byte{fixturePath: fixtureJSON, goldenPath: goldenJSON} {
+				got, err := ioutil.ReadFile(path)
+				if err != nil {
+					log.WithError(err).Errorf("Error reading %s", path)
+					drift = true
+					continue
+				}
+				if !bytes.Equal(got, want) {
+					log.Errorf("%s is out of date; rerun goldengen", path)
+					drift = true
+				}
+			}
+			continue
+		}
+
+		if err := ioutil.WriteFile(fixturePath, fixtureJSON, 0644); err != nil {
+			log.WithError(err).Fatalf("Error writing %s", fixturePath)
+		}
+		if err := ioutil.WriteFile(goldenPath, goldenJSON, 0644); err != nil {
+			log.WithError(err).Fatalf("Error writing %s", goldenPath)
+		}
+		log.Infof("Wrote %s", name)
+	}
+
+	if drift {
+		os.Exit(1)
+	}
+}
+
+// render returns the fixture and the golden output — the fixture after the
+// engine's backup mutation — as stable, indented JSON.
+func render(engine *mutation.Engine, fixture map[string]interface{}) ([]byte, []byte, error) {
+	fixtureJSON, err := marshalStable(fixture)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Round-trip through JSON so the engine gets its own copy to mutate.
+	item := &unstructured.Unstructured{}
+	if err := json.Unmarshal(fixtureJSON, &item.Object); err != nil {
+		return nil, nil, err
+	}
+
+	if err := engine.ApplyBackup(item); err != nil {
+		return nil, nil, err
+	}
+
+	goldenJSON, err := marshalStable(item.Object)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return fixtureJSON, goldenJSON, nil
+}
+
+// marshalStable renders JSON with sorted keys and trailing newline, so
+// goldens diff cleanly.
+func marshalStable(content map[string]interface{}) ([]byte, error) {
+	out, err := json.MarshalIndent(content, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
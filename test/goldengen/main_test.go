@@ -0,0 +1,68 @@
+/*
+Copyright 2018 the Heptio Ark contributors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	arktest "github.com/heptio/ark/pkg/util/test"
+
+	"github.com/skriss/ark-scale-deployment/pkg/mutation"
+)
+
+// TestGoldenFixturesUpToDate recomputes every fixture and golden with the
+// default mutation rules and compares them to the checked-in files in
+// test/fixtures, the same check `goldengen -verify` runs in CI.
+func TestGoldenFixturesUpToDate(t *testing.T) {
+	engine, err := mutation.NewEngineFromEnv(arktest.NewLogger())
+	require.NoError(t, err)
+
+	for name, fixture := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			fixtureJSON, goldenJSON, err := render(engine, fixture)
+			require.NoError(t, err)
+
+			checkedIn, err := ioutil.ReadFile(filepath.Join("..", "fixtures", name+".json"))
+			require.NoError(t, err)
+			assert.Equal(t, string(checkedIn), string(fixtureJSON), "%s.json is out of date; rerun goldengen", name)
+
+			checkedIn, err = ioutil.ReadFile(filepath.Join("..", "fixtures", name+".golden.json"))
+			require.NoError(t, err)
+			assert.Equal(t, string(checkedIn), string(goldenJSON), "%s.golden.json is out of date; rerun goldengen", name)
+		})
+	}
+}
+
+// TestGoldensPinDefaultRules spot-checks the intent of the goldens: the
+// default rules zero replicas on apps-group Deployments only.
+func TestGoldensPinDefaultRules(t *testing.T) {
+	engine, err := mutation.NewEngineFromEnv(arktest.NewLogger())
+	require.NoError(t, err)
+
+	_, golden, err := render(engine, fixtures["deployment-apps-v1"])
+	require.NoError(t, err)
+	assert.Contains(t, string(golden), `"replicas": 0`)
+
+	fixtureJSON, golden, err := render(engine, fixtures["deployment-extensions-v1beta1"])
+	require.NoError(t, err)
+	assert.Equal(t, string(fixtureJSON), string(golden))
+}